@@ -0,0 +1,79 @@
+package stats
+
+import (
+	"sync"
+	"time"
+
+	"github.com/supershal/stats/metrics"
+)
+
+// concurrency counts the requests in flight of one tag set and the highest count of the current window.
+// Both are recorded in their gauges on every change, so the gauges come back after the registry was reset.
+type concurrency struct {
+	inFlight *metrics.Gauge
+	max      *metrics.Gauge
+	window   time.Duration
+	timer    *time.Timer
+
+	mu      sync.Mutex
+	current int64
+	peak    int64
+}
+
+func newConcurrency(reg *metrics.Registry, tags map[string]string, window time.Duration) *concurrency {
+	c := &concurrency{
+		inFlight: reg.NewGauge("http_request", tags, "in_flight"),
+		max:      reg.NewGauge("http_request", tags, "max_concurrency"),
+		window:   window,
+	}
+	c.timer = time.AfterFunc(window, c.rotate)
+	return c
+}
+
+func (c *concurrency) inc() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current++
+	if c.current > c.peak {
+		c.peak = c.current
+	}
+	c.inFlight.Set(c.current)
+	c.max.Set(c.peak)
+}
+
+func (c *concurrency) dec() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current--
+	c.inFlight.Set(c.current)
+	c.max.Set(c.peak)
+}
+
+// rotate starts a new window whose peak is the current count.
+func (c *concurrency) rotate() {
+	c.mu.Lock()
+	c.peak = c.current
+	c.max.Set(c.peak)
+	c.mu.Unlock()
+	c.timer.Reset(c.window)
+}
+
+// concurrencyTracker keeps the concurrency of each tag set and reports it as the http_request gauges
+// in_flight and max_concurrency. max_concurrency starts over from the number of requests in flight every window,
+// independent of how often the registry is read.
+type concurrencyTracker struct {
+	sets *tagSets
+}
+
+func newConcurrencyTracker(reg *metrics.Registry, window time.Duration) *concurrencyTracker {
+	return &concurrencyTracker{
+		sets: newTagSets(func(tags map[string]string) interface{} {
+			return newConcurrency(reg, tags, window)
+		}),
+	}
+}
+
+// get returns the concurrency of tags and creates it when it does not exist.
+func (t *concurrencyTracker) get(tags map[string]string) *concurrency {
+	return t.sets.get(tags).(*concurrency)
+}
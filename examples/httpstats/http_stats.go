@@ -59,7 +59,7 @@ func middlewares() alice.Chain {
 	tags := map[string]string{
 		"host": host,
 	}
-	s := stats.NewHTTPStats(tags)
+	s := stats.NewHTTPStats(tags, stats.WithRouteTag(stats.MuxRoute))
 	return alice.New(s.HTTPStatsHandler)
 }
 
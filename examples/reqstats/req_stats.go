@@ -98,16 +98,17 @@ func requestStatsHandler(next http.Handler) http.Handler {
 		// custom response writer
 		t := time.Now()
 		rlw := &stats.StatsWriter{Writer: w, StartTime: t}
-		next.ServeHTTP(rlw, r)
-		// extract uri. any request parameters or headers can be extracted and passed as tags.
-		uri := r.RequestURI
+		next.ServeHTTP(rlw.ResponseWriter(), r)
+		// extract route template rather than raw uri to keep number of series bounded.
+		// any request parameters or headers can be extracted and passed as tags.
+		route := stats.MuxRoute(r)
 		tags := map[string]string{}
 		for k, v := range globaltags {
 			tags[k] = v
 		}
-		tags["uri"] = uri
+		tags["route"] = route
 
-		go s.LogRequestStat(*r, tags)
-		go s.LogResponseStat(rlw, tags)
+		s.LogRequestStat(r, tags)
+		s.LogResponseStat(rlw, tags)
 	})
 }
@@ -1,24 +1,68 @@
 package stats
 
 import (
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	gmux "github.com/gorilla/mux"
+	"github.com/supershal/stats/metrics"
 )
 
 //ServeMetrics starts Http server to provide current metrics in influxdb line protocol format.
 // It takes port number and path as input.: example  ServeMetrics(8081, "/metrics").
+// Metrics in Graphite plaintext format are served at path + "/graphite", example "/metrics/graphite".
+// Metrics in Prometheus text format are served at path + "/prometheus", or at path when the request
+// accepts "text/plain; version=0.0.4" as Prometheus scrapers do.
 func ServeMetrics(port int, path string) error {
-	g := gmux.NewRouter()
-	g.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte(HTTPMetricsSnapshotLines()))
-	}).Methods("GET")
-
 	addr := ":" + strconv.Itoa(port)
-	err := http.ListenAndServe(addr, g)
+	err := http.ListenAndServe(addr, MetricsHandler(path, metrics.DefaultRegistry))
 	if err != nil {
 		return err
 	}
 	return nil
 }
+
+// MetricsHandler returns a handler which serves metrics of registry r under path in the same formats as ServeMetrics.
+// Use it to serve the registry of an HTTPStats created with WithRegistry.
+func MetricsHandler(path string, r *metrics.Registry) http.Handler {
+	g := gmux.NewRouter()
+	g.HandleFunc(path, func(w http.ResponseWriter, req *http.Request) {
+		if acceptsPrometheus(req.Header.Get("Accept")) {
+			writePrometheus(w, r)
+			return
+		}
+		w.Write([]byte(r.SnapshotLines()))
+	}).Methods("GET")
+
+	g.HandleFunc(path+"/graphite", func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(r.SnapshotGraphite(time.Now())))
+	}).Methods("GET")
+
+	g.HandleFunc(path+"/prometheus", func(w http.ResponseWriter, req *http.Request) {
+		writePrometheus(w, r)
+	}).Methods("GET")
+	return g
+}
+
+// writePrometheus writes metrics of registry r in Prometheus text exposition format.
+func writePrometheus(w http.ResponseWriter, r *metrics.Registry) {
+	w.Header().Set("Content-Type", metrics.PrometheusContentType)
+	w.Write([]byte(r.SnapshotPrometheus()))
+}
+
+// acceptsPrometheus reports whether Accept header asks for Prometheus text format version 0.0.4.
+func acceptsPrometheus(accept string) bool {
+	for _, r := range strings.Split(accept, ",") {
+		mt, params, err := mime.ParseMediaType(r)
+		if err != nil {
+			continue
+		}
+		if mt == "text/plain" && params["version"] == "0.0.4" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package stats
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/supershal/stats/metrics"
+)
+
+func TestMetricsRouterGraphite(t *testing.T) {
+	t.Parallel()
+	reg := metrics.NewRegistry()
+	reg.NewCounter("http_response", map[string]string{"foo": "bar"}, "200").Add()
+
+	server := httptest.NewServer(MetricsHandler("/metrics", reg))
+	defer server.Close()
+
+	res, err := http.Get(server.URL + "/metrics/graphite")
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	assert.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, string(body), "http_response.foo.bar.200 1 ")
+}
+
+func TestMetricsRouterPrometheus(t *testing.T) {
+	t.Parallel()
+	reg := metrics.NewRegistry()
+	reg.NewCounter("http_response", map[string]string{"foo": "bar"}, "200").Add()
+
+	server := httptest.NewServer(MetricsHandler("/metrics", reg))
+	defer server.Close()
+
+	get := func(path, accept string) (string, string) {
+		req, _ := http.NewRequest("GET", server.URL+path, nil)
+		req.Header.Set("Accept", accept)
+		res, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		assert.NoError(t, err)
+		return res.Header.Get("Content-Type"), string(body)
+	}
+
+	ct, body := get("/metrics/prometheus", "")
+	assert.Equal(t, metrics.PrometheusContentType, ct)
+	assert.Contains(t, body, `http_response{field="200",foo="bar"} 1`)
+
+	ct, body = get("/metrics", "application/openmetrics-text;version=1.0.0;q=0.75,text/plain;version=0.0.4;q=0.5,*/*;q=0.1")
+	assert.Equal(t, metrics.PrometheusContentType, ct)
+	assert.Contains(t, body, "# TYPE http_response counter")
+
+	_, body = get("/metrics", "*/*")
+	assert.Contains(t, body, "http_response,foo=bar 200=1")
+}
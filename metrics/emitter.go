@@ -0,0 +1,37 @@
+package metrics
+
+// Emitter receives every update made through Counter.Add, Gauge.Set, Histogram.RecordValue, Meter.Mark and Timer.Update
+// in addition to the values kept for snapshots. Meter marks are emitted as counts and timer durations, in the unit of
+// the timer, as histogram values. Updates of series over the registry Limits are emitted the way they are recorded,
+// folded into the "other" series or not at all. StatsdClient is an Emitter.
+type Emitter interface {
+	Count(name string, tags map[string]string, field string, delta uint64)
+	Gauge(name string, tags map[string]string, field string, value int64)
+	Histogram(name string, tags map[string]string, field string, value int64)
+}
+
+// FloatEmitter is implemented by an Emitter which also receives the updates of FloatCounter and FloatGauge.
+// A FloatGauge.Add is emitted as the resulting value of the gauge.
+type FloatEmitter interface {
+	FloatCount(name string, tags map[string]string, field string, delta float64)
+	FloatGauge(name string, tags map[string]string, field string, value float64)
+}
+
+// SetEmitter installs e to receive all metric updates of the DefaultRegistry. Pass nil to stop emitting.
+func SetEmitter(e Emitter) {
+	DefaultRegistry.SetEmitter(e)
+}
+
+// SetEmitter installs e to receive all metric updates of the registry. Pass nil to stop emitting.
+func (r *Registry) SetEmitter(e Emitter) {
+	r.mu.Lock()
+	r.emitter = e
+	r.mu.Unlock()
+}
+
+// currentEmitter returns the installed Emitter or nil.
+func (r *Registry) currentEmitter() Emitter {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.emitter
+}
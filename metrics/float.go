@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// FloatCounter is a Counter holding a float64, e.g. the total number of seconds spent in garbage collection.
+type FloatCounter struct {
+	Name     string
+	Tags     map[string]string
+	Field    string
+	registry *Registry
+	series   string // counter series.
+}
+
+// NewFloatCounter returns new instance of FloatCounter in the DefaultRegistry.
+func NewFloatCounter(name string, tags map[string]string, field string) *FloatCounter {
+	return DefaultRegistry.NewFloatCounter(name, tags, field)
+}
+
+// Add increments the counter by delta. A counter never decreases, so negative deltas are ignored.
+func (c *FloatCounter) Add(delta float64) {
+	if delta < 0 || math.IsNaN(delta) {
+		return
+	}
+	e := c.registry.floatCounter(c.Name, c.Tags, c.Field, c.series)
+	if e == nil {
+		return
+	}
+	addFloat(&e.bits, delta)
+	if em, ok := c.registry.currentEmitter().(FloatEmitter); ok {
+		em.FloatCount(e.name, e.tags, e.field, delta)
+	}
+}
+
+// SetFunc sets the counter's value to the lazily-called return value of the given function.
+func (c *FloatCounter) SetFunc(f func() float64) {
+	c.SetBatchFunc(nil, nil, f)
+}
+
+// SetBatchFunc sets the counter's value to the lazily-called return value of the given function,
+// with an additional initializer function for a related batch of counters, all of which are keyed
+// by an arbitrary value. init is called once per snapshot for each key.
+func (c *FloatCounter) SetBatchFunc(key interface{}, init func(), f func() float64) {
+	c.registry.setFloatCounterFunc(c.Name, c.Tags, c.Field, c.series, key, init, f)
+}
+
+// Remove removes the counter.
+func (c *FloatCounter) Remove() {
+	c.registry.removeFloatCounter(c.series)
+}
+
+// FloatGauge is a Gauge holding a float64, e.g. CPU load or a cache hit ratio.
+type FloatGauge struct {
+	Name     string
+	Tags     map[string]string
+	Field    string
+	registry *Registry
+	series   string // gauge series.
+}
+
+// NewFloatGauge returns new instance of FloatGauge in the DefaultRegistry.
+func NewFloatGauge(name string, tags map[string]string, field string) *FloatGauge {
+	return DefaultRegistry.NewFloatGauge(name, tags, field)
+}
+
+// Set sets the gauge's value. A NaN or infinite value is left out of snapshots until it is replaced.
+func (g *FloatGauge) Set(value float64) {
+	e := g.registry.floatGauge(g.Name, g.Tags, g.Field, g.series)
+	if e == nil {
+		return
+	}
+	atomic.StoreUint64(&e.bits, math.Float64bits(value))
+	if em, ok := g.registry.currentEmitter().(FloatEmitter); ok {
+		em.FloatGauge(e.name, e.tags, e.field, value)
+	}
+}
+
+// Add adds delta, which may be negative, to the gauge's value.
+func (g *FloatGauge) Add(delta float64) {
+	e := g.registry.floatGauge(g.Name, g.Tags, g.Field, g.series)
+	if e == nil {
+		return
+	}
+	value := addFloat(&e.bits, delta)
+	if em, ok := g.registry.currentEmitter().(FloatEmitter); ok {
+		em.FloatGauge(e.name, e.tags, e.field, value)
+	}
+}
+
+// SetFunc sets the gauge's value to the lazily-called return value of the given function.
+func (g *FloatGauge) SetFunc(f func() float64) {
+	g.SetBatchFunc(nil, nil, f)
+}
+
+// SetBatchFunc sets the gauge's value to the lazily-called return value of the given function,
+// with an additional initializer function for a related batch of gauges, all of which are keyed
+// by an arbitrary value. init is called once per snapshot for each key.
+func (g *FloatGauge) SetBatchFunc(key interface{}, init func(), f func() float64) {
+	g.registry.setFloatGaugeFunc(g.Name, g.Tags, g.Field, g.series, key, init, f)
+}
+
+// Remove removes the gauge.
+func (g *FloatGauge) Remove() {
+	g.registry.removeFloatGauge(g.series)
+}
+
+// floatEntry holds the value of a float counter or gauge.
+type floatEntry struct {
+	bits uint64 // float64 bits accessed atomically, first in struct for 64-bit alignment.
+	seriesInfo
+	fn func() float64
+	batchFunc
+}
+
+// value returns the value of the entry. batch is called before a value function.
+func (e *floatEntry) value(batch func(batchFunc)) float64 {
+	if e.fn != nil {
+		batch(e.batchFunc)
+		return e.fn()
+	}
+	return math.Float64frombits(atomic.LoadUint64(&e.bits))
+}
+
+// addFloat atomically adds delta to the float64 stored as bits in addr and returns the new value.
+func addFloat(addr *uint64, delta float64) float64 {
+	for {
+		old := atomic.LoadUint64(addr)
+		v := math.Float64frombits(old) + delta
+		if atomic.CompareAndSwapUint64(addr, old, math.Float64bits(v)) {
+			return v
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SnapshotGraphite provides all collected metrics of the DefaultRegistry in Graphite plaintext protocol format.
+// See Registry.SnapshotGraphite.
+func SnapshotGraphite(t time.Time) string {
+	return DefaultRegistry.SnapshotGraphite(t)
+}
+
+// SnapshotGraphite provides all collected metrics in Graphite plaintext protocol format, one metric per line:
+// <path> <value> <timestamp>. The timestamp is t in unix seconds. http://graphite.readthedocs.io/en/latest/feeding-carbon.html
+func (r *Registry) SnapshotGraphite(t time.Time) string {
+	var buffer bytes.Buffer
+	ts := strconv.FormatInt(t.Unix(), 10)
+	for _, s := range r.collect() {
+		buffer.WriteString(graphitePath(s.measurement, s.tags, s.field, s.stat))
+		buffer.WriteString(" ")
+		buffer.WriteString(formatValue(s.value))
+		buffer.WriteString(" ")
+		buffer.WriteString(ts)
+		buffer.WriteString("\n")
+	}
+	return buffer.String()
+}
+
+// MakeGraphitePath creates dotted Graphite metric path: <measurement>.<tag1>.<value1>.<tagN>.<valueN>.<field>
+// Tags are sorted by key and characters that are not allowed in a path node are replaced by underscore.
+func MakeGraphitePath(name string, tags map[string]string, field string) string {
+	return graphitePath(name, tags, field, "")
+}
+
+// graphitePath creates a Graphite metric path and appends the histogram statistic, if any.
+// Tags with an empty value and an empty field are left out as carbon does not accept empty path nodes.
+func graphitePath(name string, tags map[string]string, field, stat string) string {
+	nodes := []string{graphiteNode(name)}
+	for _, k := range sortedKeys(tags) {
+		if tags[k] == "" {
+			continue
+		}
+		nodes = append(nodes, graphiteNode(k), graphiteNode(tags[k]))
+	}
+	if field != "" {
+		nodes = append(nodes, graphiteNode(field))
+	}
+	if stat != "" {
+		nodes = append(nodes, graphiteNode(stat))
+	}
+	return strings.Join(nodes, ".")
+}
+
+// graphiteNode replaces characters that carbon treats as separators or does not accept in a path node.
+func graphiteNode(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// formatValue formats a sample value as decimal number.
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case uint64:
+		return strconv.FormatUint(v, 10)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return ""
+}
@@ -0,0 +1,224 @@
+package metrics
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codahale/hdrhistogram"
+)
+
+// A Histogram measures the distribution of a stream of values.
+// Use a histogram to track the distribution of a stream of values (e.g., the
+// latency associated with HTTP requests).
+//
+// Percentiles, by default P50, P75, P90, P95, P99 and P999, are reported over the values recorded
+// in a window of sub-histograms, together with the count, sum, min, max, mean and stddev of those values.
+// The oldest sub-histogram is dropped every Window/Buckets, by default every minute of a five minute window,
+// so old values decay instead of accumulating for the lifetime of the process. Taking a snapshot does not change it.
+type Histogram struct {
+	Name     string
+	Tags     map[string]string
+	Field    string
+	registry *Registry
+	series   string
+	opts     HistogramOptions
+}
+
+// HistogramOptions configures a histogram created by NewHistogramWithOptions.
+type HistogramOptions struct {
+	// Min and Max are the lowest and highest values the histogram tracks. Values above Max are not recorded.
+	Min int64
+	Max int64
+
+	// SigFigs is the number of significant decimal digits values are tracked with, between 1 and 5. Default is 3.
+	SigFigs int
+
+	// Quantiles are the percentiles between 0 and 100 reported for the histogram, e.g. 99.9 is reported as P999.
+	// Default is DefaultQuantiles.
+	Quantiles []float64
+
+	// Window is the time span values are reported over, e.g. time.Minute. The window is a ring of Buckets
+	// sub-histograms, each covering Window/Buckets, and the oldest is replaced when its time is up.
+	// Default is 5 minutes.
+	Window time.Duration
+
+	// Buckets is the number of sub-histograms in the window. Default is 5.
+	Buckets int
+}
+
+// DefaultQuantiles are the percentiles reported by histograms which do not configure Quantiles.
+var DefaultQuantiles = []float64{50, 75, 90, 95, 99, 99.9}
+
+// NewHistogram returns new instance of Histogram in the DefaultRegistry which tracks values between minValue and maxValue.
+func NewHistogram(name string, tags map[string]string, field string, minValue, maxValue int64) *Histogram {
+	return DefaultRegistry.NewHistogram(name, tags, field, minValue, maxValue)
+}
+
+// NewHistogramWithOptions returns new instance of Histogram in the DefaultRegistry configured by opts.
+func NewHistogramWithOptions(name string, tags map[string]string, field string, opts HistogramOptions) *Histogram {
+	return DefaultRegistry.NewHistogramWithOptions(name, tags, field, opts)
+}
+
+// RecordValue records the given value.
+func (h *Histogram) RecordValue(v int64) error {
+	e := h.registry.histogram(h.Name, h.Tags, h.Field, h.series, h.opts, true)
+	if e == nil {
+		return nil
+	}
+	err := e.recordValue(v)
+	if em := h.registry.currentEmitter(); em != nil {
+		em.Histogram(e.name, e.tags, e.field, v)
+	}
+	return err
+}
+
+// Remove removes the histogram and its percentiles.
+func (h *Histogram) Remove() {
+	h.registry.removeHistogram(h.series)
+}
+
+// percentile is a reported quantile of a histogram.
+type percentile struct {
+	name string  // e.g. P999
+	q    float64 // e.g. 99.9
+}
+
+// defaultHistogramWindow is the time span of a histogram which does not configure Window.
+const defaultHistogramWindow = 5 * time.Minute
+
+// defaultHistogramBuckets is the number of sub-histograms of a histogram which does not configure Buckets.
+const defaultHistogramBuckets = 5
+
+// histogramEntry holds the recorded values of a histogram in a ring of sub-histograms
+// which are rotated every interval.
+type histogramEntry struct {
+	seriesInfo
+	percentiles []percentile
+	interval    time.Duration // time covered by each sub-histogram.
+
+	mu      sync.Mutex
+	hist    *hdrhistogram.WindowedHistogram
+	sums    []int64   // exact sum of the values of each sub-histogram.
+	cur     int       // index of the current sub-histogram in sums.
+	rotated time.Time // start of the current sub-histogram.
+	count   uint64    // number of values recorded since the histogram was created.
+	sum     int64     // sum of the values recorded since the histogram was created.
+}
+
+func newHistogramEntry(info seriesInfo, opts HistogramOptions) *histogramEntry {
+	if opts.SigFigs <= 0 {
+		opts.SigFigs = 3
+	} else if opts.SigFigs > 5 {
+		opts.SigFigs = 5
+	}
+	quantiles := opts.Quantiles
+	if len(quantiles) == 0 {
+		quantiles = DefaultQuantiles
+	}
+	quantiles = append([]float64(nil), quantiles...)
+	sort.Float64s(quantiles)
+
+	percentiles := make([]percentile, 0, len(quantiles))
+	for _, q := range quantiles {
+		if q <= 0 || q > 100 {
+			continue
+		}
+		percentiles = append(percentiles, percentile{
+			name: "P" + strings.Replace(strconv.FormatFloat(q, 'f', -1, 64), ".", "", 1),
+			q:    q,
+		})
+	}
+
+	buckets := opts.Buckets
+	if buckets <= 0 {
+		buckets = defaultHistogramBuckets
+	}
+	window := opts.Window
+	if window <= 0 {
+		window = defaultHistogramWindow
+	}
+	interval := window / time.Duration(buckets)
+	if interval <= 0 {
+		interval = 1
+	}
+
+	return &histogramEntry{
+		seriesInfo:  info,
+		percentiles: percentiles,
+		interval:    interval,
+		hist:        hdrhistogram.NewWindowed(buckets, opts.Min, opts.Max, opts.SigFigs),
+		sums:        make([]int64, buckets),
+		rotated:     time.Now(),
+	}
+}
+
+// recordValue records v.
+func (e *histogramEntry) recordValue(v int64) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.advance(time.Now())
+	if err := e.hist.Current.RecordValue(v); err != nil {
+		return err
+	}
+	e.sums[e.cur] += v
+	e.count++
+	e.sum += v
+	return nil
+}
+
+// rotate replaces the oldest sub-histogram by an empty one which becomes the current sub-histogram.
+func (e *histogramEntry) rotate() {
+	e.hist.Rotate()
+	e.cur = (e.cur + 1) % len(e.sums)
+	e.sums[e.cur] = 0
+}
+
+// advance rotates once for every interval elapsed since the current sub-histogram started.
+func (e *histogramEntry) advance(now time.Time) {
+	n := now.Sub(e.rotated) / e.interval
+	if n <= 0 {
+		return
+	}
+	e.rotated = e.rotated.Add(n * e.interval)
+	if n > time.Duration(len(e.sums)) {
+		n = time.Duration(len(e.sums))
+	}
+	for ; n > 0; n-- {
+		e.rotate()
+	}
+}
+
+// samples returns the percentiles and statistics of the values recorded in the window.
+// The count and sum samples carry the cumulative count and sum as their total.
+func (e *histogramEntry) samples() []sample {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.advance(time.Now())
+	m := e.hist.Merge()
+	var sum int64
+	for _, s := range e.sums {
+		sum += s
+	}
+
+	samples := make([]sample, 0, len(e.percentiles)+6)
+	for _, p := range e.percentiles {
+		s := e.sample(histogramKind, p.name, m.ValueAtQuantile(p.q))
+		s.quantile = p.q / 100
+		samples = append(samples, s)
+	}
+	count := e.sample(histogramKind, "count", uint64(m.TotalCount()))
+	count.total = e.count
+	total := e.sample(histogramKind, "sum", sum)
+	total.total = e.sum
+	return append(samples,
+		count,
+		total,
+		e.sample(histogramKind, "min", m.Min()),
+		e.sample(histogramKind, "max", m.Max()),
+		e.sample(histogramKind, "mean", m.Mean()),
+		e.sample(histogramKind, "stddev", m.StdDev()),
+	)
+}
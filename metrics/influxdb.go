@@ -0,0 +1,210 @@
+package metrics
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// InfluxDBConfig configures InfluxDBReporter. Only URL and Database are required.
+type InfluxDBConfig struct {
+	// URL of the InfluxDB server, e.g. http://localhost:8086
+	URL string
+
+	// Database to write to.
+	Database string
+
+	// RetentionPolicy to write to. The default retention policy of the database is used when empty.
+	RetentionPolicy string
+
+	// Username and Password for authentication. Optional.
+	Username string
+	Password string
+
+	// Precision of point timestamps: time.Nanosecond (default), time.Microsecond, time.Millisecond or time.Second.
+	Precision time.Duration
+
+	// Interval between reports. Default is 10 seconds.
+	Interval time.Duration
+
+	// BatchSize is the maximum number of points sent in one write request. Default is 5000.
+	BatchSize int
+
+	// Group writes all fields of a series as a single point. See LineOptions.
+	Group bool
+
+	// MaxRetries is the number of times a failed write request is retried. Default is 3, a negative value
+	// disables retries. Requests rejected with a 4xx status code are not retried, nor are requests after Stop.
+	MaxRetries int
+
+	// RetryBackoff is the wait before the first retry. It is doubled after each retry. Default is 1 second.
+	RetryBackoff time.Duration
+
+	// Client used to send write requests. Default is a client with 10 seconds timeout.
+	Client *http.Client
+
+	// ErrorHandler is called when a periodic report fails. Default logs the error.
+	ErrorHandler func(error)
+
+	// Registry to report. Default is the DefaultRegistry.
+	Registry *Registry
+}
+
+// InfluxDBReporter periodically writes a snapshot of all metrics to the InfluxDB HTTP /write endpoint.
+// https://docs.influxdata.com/influxdb/v1.0/guides/writing_data/
+type InfluxDBReporter struct {
+	cfg      InfluxDBConfig
+	writeURL string
+	ticker   *ticker
+}
+
+// NewInfluxDBReporter returns new instance of InfluxDBReporter. Call Start to begin reporting and Stop to flush
+// the last snapshot on shutdown.
+func NewInfluxDBReporter(cfg InfluxDBConfig) (*InfluxDBReporter, error) {
+	if cfg.URL == "" || cfg.Database == "" {
+		return nil, errors.New("metrics: InfluxDB URL and Database are required")
+	}
+	u, err := url.Parse(strings.TrimSuffix(cfg.URL, "/") + "/write")
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.Precision <= 0 {
+		cfg.Precision = time.Nanosecond
+	}
+	precision, ok := influxPrecisions[cfg.Precision]
+	if !ok {
+		return nil, fmt.Errorf("metrics: unsupported InfluxDB precision %v", cfg.Precision)
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = 5000
+	}
+	if cfg.MaxRetries < 0 {
+		cfg.MaxRetries = 0
+	} else if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = 3
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = time.Second
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.ErrorHandler == nil {
+		cfg.ErrorHandler = logError
+	}
+	if cfg.Registry == nil {
+		cfg.Registry = DefaultRegistry
+	}
+
+	q := u.Query()
+	q.Set("db", cfg.Database)
+	q.Set("precision", precision)
+	if cfg.RetentionPolicy != "" {
+		q.Set("rp", cfg.RetentionPolicy)
+	}
+	u.RawQuery = q.Encode()
+
+	r := &InfluxDBReporter{
+		cfg:      cfg,
+		writeURL: u.String(),
+	}
+	r.ticker = newTicker(cfg.Interval, func() {
+		if err := r.Report(); err != nil {
+			r.cfg.ErrorHandler(err)
+		}
+	})
+	return r, nil
+}
+
+// influxPrecisions maps supported timestamp precisions to the precision parameter of the /write endpoint.
+var influxPrecisions = map[time.Duration]string{
+	time.Nanosecond:  "ns",
+	time.Microsecond: "u",
+	time.Millisecond: "ms",
+	time.Second:      "s",
+}
+
+// Start starts reporting in the background every configured interval.
+func (r *InfluxDBReporter) Start() {
+	r.ticker.start()
+}
+
+// Stop stops background reporting and writes a final snapshot so no metrics are lost on shutdown.
+func (r *InfluxDBReporter) Stop() error {
+	r.ticker.close()
+	return r.Report()
+}
+
+// Report writes the current snapshot to InfluxDB in batches of at most BatchSize points.
+func (r *InfluxDBReporter) Report() error {
+	lines := r.cfg.Registry.SnapshotLinesWithOptions(LineOptions{
+		Timestamp: time.Now(),
+		Precision: r.cfg.Precision,
+		Group:     r.cfg.Group,
+	})
+	points := splitLines(lines)
+
+	for len(points) > 0 {
+		n := r.cfg.BatchSize
+		if n > len(points) {
+			n = len(points)
+		}
+		if err := r.writeRetry([]byte(strings.Join(points[:n], "\n") + "\n")); err != nil {
+			return err
+		}
+		points = points[n:]
+	}
+	return nil
+}
+
+// writeRetry writes a batch and retries failed requests with exponential backoff.
+// Stop ends the backoff, so a failing server does not delay shutdown, and the final report is not retried.
+func (r *InfluxDBReporter) writeRetry(body []byte) error {
+	backoff := r.cfg.RetryBackoff
+	var err error
+	for i := 0; ; i++ {
+		var retry bool
+		if retry, err = r.write(body); err == nil || !retry || i >= r.cfg.MaxRetries {
+			return err
+		}
+		if !r.ticker.wait(backoff) {
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// write sends one write request. It reports whether a failed request can be retried.
+func (r *InfluxDBReporter) write(body []byte) (retry bool, err error) {
+	req, err := http.NewRequest("POST", r.writeURL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if r.cfg.Username != "" || r.cfg.Password != "" {
+		req.SetBasicAuth(r.cfg.Username, r.cfg.Password)
+	}
+
+	res, err := r.cfg.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode/100 == 2 {
+		io.Copy(ioutil.Discard, res.Body)
+		return false, nil
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	return res.StatusCode/100 == 5, fmt.Errorf("metrics: InfluxDB write failed with %s: %s", res.Status, bytes.TrimSpace(msg))
+}
@@ -0,0 +1,178 @@
+package metrics_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/supershal/stats/metrics"
+)
+
+// influxServer records the write requests received by a fake InfluxDB server.
+type influxServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	queries  []string
+	bodies   []string
+	failures int // number of requests to fail with 503 before accepting writes.
+}
+
+func newInfluxServer() *influxServer {
+	s := &influxServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if r.URL.Path != "/write" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if s.failures > 0 {
+			s.failures--
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		s.queries = append(s.queries, r.URL.RawQuery)
+		s.bodies = append(s.bodies, string(body))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	return s
+}
+
+func (s *influxServer) writes() ([]string, []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.queries...), append([]string(nil), s.bodies...)
+}
+
+func TestInfluxDBReporterBatches(t *testing.T) {
+	metrics.Reset()
+	server := newInfluxServer()
+	defer server.Close()
+
+	for _, f := range []string{"a", "b", "c"} {
+		metrics.NewCounter("foo", map[string]string{"bar": "baz"}, f).Add()
+	}
+
+	r, err := metrics.NewInfluxDBReporter(metrics.InfluxDBConfig{
+		URL:             server.URL,
+		Database:        "stats",
+		RetentionPolicy: "week",
+		Precision:       time.Second,
+		BatchSize:       2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Report(); err != nil {
+		t.Fatal(err)
+	}
+
+	queries, bodies := server.writes()
+	if len(bodies) != 2 {
+		t.Fatalf("Writes were %v, but expected 2 batches", bodies)
+	}
+	if v, want := queries[0], "db=stats&precision=s&rp=week"; v != want {
+		t.Errorf("Query was %v, but expected %v", v, want)
+	}
+	if v := bodies[0]; !strings.HasPrefix(v, "foo,bar=baz a=1i ") || strings.Count(v, "\n") != 2 {
+		t.Errorf("First batch was %v, but expected fields a and b", v)
+	}
+	if v := bodies[1]; !strings.HasPrefix(v, "foo,bar=baz c=1i ") || strings.Count(v, "\n") != 1 {
+		t.Errorf("Second batch was %v, but expected field c", v)
+	}
+	ts := strings.TrimSpace(bodies[1][strings.LastIndex(bodies[1], " "):])
+	if len(ts) != 10 {
+		t.Errorf("Timestamp was %v, but expected seconds precision", ts)
+	}
+}
+
+func TestInfluxDBReporterRetry(t *testing.T) {
+	metrics.Reset()
+	server := newInfluxServer()
+	defer server.Close()
+	server.failures = 2
+
+	metrics.NewCounter("foo", nil, "value").Add()
+
+	r, err := metrics.NewInfluxDBReporter(metrics.InfluxDBConfig{
+		URL:          server.URL,
+		Database:     "stats",
+		MaxRetries:   2,
+		RetryBackoff: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Report(); err != nil {
+		t.Fatalf("Report failed after retries: %v", err)
+	}
+	if _, bodies := server.writes(); len(bodies) != 1 {
+		t.Errorf("Writes were %v, but expected 1", bodies)
+	}
+
+	server.mu.Lock()
+	server.failures = 3
+	server.mu.Unlock()
+	if err := r.Report(); err == nil {
+		t.Errorf("Report succeeded, but expected an error after 2 retries")
+	}
+}
+
+func TestInfluxDBReporterStopFlushes(t *testing.T) {
+	metrics.Reset()
+	server := newInfluxServer()
+	defer server.Close()
+
+	metrics.NewCounter("foo", nil, "value").Add()
+
+	r, err := metrics.NewInfluxDBReporter(metrics.InfluxDBConfig{
+		URL:      server.URL,
+		Database: "stats",
+		Interval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Start()
+	if err := r.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if _, bodies := server.writes(); len(bodies) != 1 || !strings.HasPrefix(bodies[0], "foo value=1i ") {
+		t.Errorf("Writes were %v, but expected the final snapshot", bodies)
+	}
+}
+
+func TestInfluxDBReporterStopInterruptsBackoff(t *testing.T) {
+	metrics.Reset()
+	server := newInfluxServer()
+	defer server.Close()
+	server.failures = 1000
+
+	metrics.NewCounter("foo", nil, "value").Add()
+
+	r, err := metrics.NewInfluxDBReporter(metrics.InfluxDBConfig{
+		URL:          server.URL,
+		Database:     "stats",
+		Interval:     10 * time.Millisecond,
+		RetryBackoff: time.Hour,
+		ErrorHandler: func(error) {},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Start()
+	time.Sleep(50 * time.Millisecond)
+
+	start := time.Now()
+	if err := r.Stop(); err == nil {
+		t.Errorf("Stop succeeded, but expected the error of the final report")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Stop took %v, but expected it to end the backoff", d)
+	}
+}
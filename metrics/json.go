@@ -0,0 +1,87 @@
+package metrics
+
+import (
+	"encoding/json"
+)
+
+// jsonSnapshot is the document produced by SnapshotJSON.
+type jsonSnapshot struct {
+	Counters   []jsonValue     `json:"counters"`
+	Gauges     []jsonValue     `json:"gauges"`
+	Histograms []jsonHistogram `json:"histograms"`
+	Meters     []jsonHistogram `json:"meters"`
+}
+
+// jsonValue is a single counter or gauge value.
+type jsonValue struct {
+	Measurement string            `json:"measurement"`
+	Tags        map[string]string `json:"tags"`
+	Field       string            `json:"field"`
+	Value       interface{}       `json:"value"`
+}
+
+// jsonHistogram holds all statistics of a histogram or meter keyed by name, e.g. P99 or m1_rate.
+type jsonHistogram struct {
+	Measurement string                 `json:"measurement"`
+	Tags        map[string]string      `json:"tags"`
+	Field       string                 `json:"field"`
+	Values      map[string]interface{} `json:"values"`
+}
+
+// SnapshotJSON provides all collected metrics of the DefaultRegistry as a JSON document. See Registry.SnapshotJSON.
+func SnapshotJSON() ([]byte, error) {
+	return DefaultRegistry.SnapshotJSON()
+}
+
+// SnapshotJSON provides all collected metrics as a JSON document.
+// Counters, gauges, histograms and meters are listed separately with measurement, tags and field split out:
+//
+//	{
+//	  "counters": [{"measurement": "http_response", "tags": {"host": "a"}, "field": "200", "value": 10}],
+//	  "gauges": [{"measurement": "http_response", "tags": {"host": "a"}, "field": "size", "value": 512}],
+//	  "histograms": [{"measurement": "http_response", "tags": {"host": "a"}, "field": "latency", "values": {"P50": 12, "P99": 40}}],
+//	  "meters": [{"measurement": "http_request", "tags": {"host": "a"}, "field": "throughput", "values": {"count": 10, "m1_rate": 0.2}}]
+//	}
+func (r *Registry) SnapshotJSON() ([]byte, error) {
+	doc := jsonSnapshot{
+		Counters:   []jsonValue{},
+		Gauges:     []jsonValue{},
+		Histograms: []jsonHistogram{},
+		Meters:     []jsonHistogram{},
+	}
+
+	hists := make(map[string]int)
+	meters := make(map[string]int)
+	for _, s := range r.collect() {
+		switch s.kind {
+		case counterKind:
+			doc.Counters = append(doc.Counters, jsonValue{s.measurement, s.tags, s.field, s.value})
+		case gaugeKind:
+			doc.Gauges = append(doc.Gauges, jsonValue{s.measurement, s.tags, s.field, s.value})
+		case histogramKind:
+			doc.Histograms = appendJSONStat(doc.Histograms, hists, s)
+		case meterKind:
+			doc.Meters = appendJSONStat(doc.Meters, meters, s)
+		}
+	}
+	return json.Marshal(doc)
+}
+
+// appendJSONStat adds the statistic of sample s to the entry of its series in list,
+// which is appended when index has no entry for the series yet.
+func appendJSONStat(list []jsonHistogram, index map[string]int, s sample) []jsonHistogram {
+	series := MakeSeries(s.measurement, s.tags, s.field)
+	i, ok := index[series]
+	if !ok {
+		i = len(list)
+		index[series] = i
+		list = append(list, jsonHistogram{
+			Measurement: s.measurement,
+			Tags:        s.tags,
+			Field:       s.field,
+			Values:      make(map[string]interface{}),
+		})
+	}
+	list[i].Values[s.stat] = s.value
+	return list
+}
@@ -0,0 +1,162 @@
+package metrics
+
+import "sync/atomic"
+
+// Limits caps the number of distinct series a registry holds. A series is a measurement with one set of tag values,
+// so a tag carrying a user ID or a URI creates a new series for every distinct value.
+//
+// An update to a new series over the cap is folded into the "other" series of its measurement,
+// which has every tag value replaced by "other", or dropped when Drop is set. Each such update is
+// counted in the self-metric metrics,measurement=<measurement> series_overflow. The Emitter receives
+// folded updates with the "other" tag values and no dropped updates.
+type Limits struct {
+	// MaxSeries is the maximum number of series across all measurements. Zero means no limit.
+	MaxSeries int
+
+	// MaxSeriesPerMeasurement is the maximum number of series of each measurement. Zero means no limit.
+	MaxSeriesPerMeasurement int
+
+	// Drop discards updates of series over the cap instead of folding them into the "other" series.
+	Drop bool
+}
+
+// OtherTagValue replaces all tag values of a series folded by Limits.
+const OtherTagValue = "other"
+
+// SetLimits sets limits on the number of series of the DefaultRegistry.
+func SetLimits(l Limits) {
+	DefaultRegistry.SetLimits(l)
+}
+
+// SetLimits sets limits on the number of series of the registry. Existing series are kept even if they exceed the limits.
+func (r *Registry) SetLimits(l Limits) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.limits = l
+	r.overflows = nil
+}
+
+// overflow is the cached decision of the limits for a series over the cap, so later updates of the series
+// neither take the write lock nor check the limits again.
+type overflow struct {
+	info    seriesInfo    // series updates are recorded in.
+	series  string        // "other" series updates are folded into, empty when they are dropped.
+	counter *counterEntry // series_overflow counter of the measurement.
+}
+
+// dropped reports whether updates of the series are dropped. It is false for a nil overflow.
+func (o *overflow) dropped() bool {
+	return o != nil && o.series == ""
+}
+
+// count counts an update of the series. It does nothing for a nil overflow.
+func (o *overflow) count() {
+	if o != nil {
+		atomic.AddUint64(&o.counter.value, 1)
+	}
+}
+
+// lookup returns the series updates of series are recorded in and its overflow, which is nil for a series
+// within the limits. r.mu must be held, at least for reading.
+func (r *Registry) lookup(series string) (string, *overflow) {
+	if o, ok := r.overflows[series]; ok {
+		return o.series, o
+	}
+	return series, nil
+}
+
+// limit checks a new series against the limits. It returns the series to create, which is the "other" series
+// of the measurement when the series is over the cap and fold is allowed, or admitted false when it is dropped.
+// A series over the cap is counted as overflow if the caller is about to update it, which is not the case when
+// a histogram or timer is merely created. r.mu must be held.
+func (r *Registry) limit(info seriesInfo, series string, fold, update bool) (seriesInfo, string, bool) {
+	l := r.limits
+	if l.MaxSeries <= 0 && l.MaxSeriesPerMeasurement <= 0 {
+		return info, series, true
+	}
+	if o, ok := r.overflows[series]; ok && (fold || o.dropped()) {
+		if update {
+			o.count()
+		}
+		return o.info, o.series, !o.dropped()
+	}
+
+	key := makeSeriesKey(info.name, info.tags)
+	if r.series[info.name][key] > 0 {
+		return info, series, true
+	}
+	if (l.MaxSeries <= 0 || r.seriesTotal < l.MaxSeries) &&
+		(l.MaxSeriesPerMeasurement <= 0 || len(r.series[info.name]) < l.MaxSeriesPerMeasurement) {
+		return info, series, true
+	}
+
+	o := &overflow{info: info, counter: r.overflowCounter(info.name)}
+	if update {
+		o.count()
+	}
+	if !l.Drop && !fold {
+		return info, series, false
+	}
+	if !l.Drop {
+		other := make(map[string]string, len(info.tags))
+		for k := range info.tags {
+			other[k] = OtherTagValue
+		}
+		o.info.tags = other
+		o.series = MakeSeries(info.name, other, info.field)
+	}
+	if r.overflows == nil {
+		r.overflows = make(map[string]*overflow)
+	}
+	r.overflows[series] = o
+	return o.info, o.series, !o.dropped()
+}
+
+// overflowCounter returns the counter of updates over the limits of measurement name. r.mu must be held.
+func (r *Registry) overflowCounter(name string) *counterEntry {
+	info := seriesInfo{
+		name:  "metrics",
+		tags:  map[string]string{"measurement": name},
+		field: "series_overflow",
+	}
+	series := MakeSeries(info.name, info.tags, info.field)
+	e, ok := r.counters[series]
+	if !ok {
+		e = &counterEntry{seriesInfo: info}
+		r.counters[series] = e
+	}
+	return e
+}
+
+// track counts a new entry of a series. r.mu must be held.
+func (r *Registry) track(info seriesInfo) {
+	keys, ok := r.series[info.name]
+	if !ok {
+		keys = make(map[string]int)
+		r.series[info.name] = keys
+	}
+	key := makeSeriesKey(info.name, info.tags)
+	if keys[key] == 0 {
+		r.seriesTotal++
+	}
+	keys[key]++
+}
+
+// untrack removes a removed entry of a series from the count. The cached overflows are discarded
+// as the removal may make room for them. r.mu must be held.
+func (r *Registry) untrack(info seriesInfo) {
+	r.overflows = nil
+	keys := r.series[info.name]
+	key := makeSeriesKey(info.name, info.tags)
+	if keys[key] == 0 {
+		return
+	}
+	keys[key]--
+	if keys[key] == 0 {
+		delete(keys, key)
+		r.seriesTotal--
+		if len(keys) == 0 {
+			delete(r.series, info.name)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package metrics
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// A Meter measures the rate of events, e.g. requests per second.
+// Use a meter instead of deriving a rate from a Counter downstream, which breaks when the counter is reset.
+//
+// A meter reports the count of events, the mean rate since it was created and the one, five and fifteen
+// minute exponentially weighted moving average rates, all in events per second:
+// <measurement>,<tag1>=<key1> <field>.count=<value>i,<field>.mean_rate=<value>,<field>.m1_rate=<value>,...
+type Meter struct {
+	Name     string
+	Tags     map[string]string
+	Field    string
+	registry *Registry
+	series   string // meter series.
+}
+
+// NewMeter returns new instance of Meter in the DefaultRegistry.
+func NewMeter(name string, tags map[string]string, field string) *Meter {
+	return DefaultRegistry.NewMeter(name, tags, field)
+}
+
+// Mark records the occurrence of one event.
+func (m *Meter) Mark() {
+	m.MarkN(1)
+}
+
+// MarkN records the occurrence of n events.
+func (m *Meter) MarkN(n uint64) {
+	e := m.registry.meter(m.Name, m.Tags, m.Field, m.series)
+	if e == nil {
+		return
+	}
+	e.mark(time.Now(), n)
+	if em := m.registry.currentEmitter(); em != nil {
+		em.Count(e.name, e.tags, e.field, n)
+	}
+}
+
+// Remove removes the meter.
+func (m *Meter) Remove() {
+	m.registry.removeMeter(m.series)
+}
+
+// meterTick is the interval the moving averages are updated with.
+const meterTick = 5 * time.Second
+
+// ewma is an exponentially weighted moving average of a rate in events per second.
+type ewma struct {
+	alpha float64
+	rate  float64
+	init  bool
+}
+
+func newEWMA(window time.Duration) ewma {
+	return ewma{alpha: 1 - math.Exp(-float64(meterTick)/float64(window))}
+}
+
+// tick updates the average with the events of one tick followed by idle more ticks without events.
+func (a *ewma) tick(n uint64, idle int64) {
+	instant := float64(n) / meterTick.Seconds()
+	if a.init {
+		a.rate += a.alpha * (instant - a.rate)
+	} else {
+		a.rate = instant
+		a.init = true
+	}
+	a.rate *= math.Pow(1-a.alpha, float64(idle))
+}
+
+// meterEntry holds the count and moving averages of a meter. The averages are updated lazily,
+// on every mark and snapshot, for all ticks elapsed since the last update.
+type meterEntry struct {
+	seriesInfo
+
+	mu        sync.Mutex
+	count     uint64
+	uncounted uint64 // events since the last tick.
+	start     time.Time
+	lastTick  time.Time
+	m1        ewma
+	m5        ewma
+	m15       ewma
+}
+
+func newMeterEntry(info seriesInfo, now time.Time) *meterEntry {
+	return &meterEntry{
+		seriesInfo: info,
+		start:      now,
+		lastTick:   now,
+		m1:         newEWMA(time.Minute),
+		m5:         newEWMA(5 * time.Minute),
+		m15:        newEWMA(15 * time.Minute),
+	}
+}
+
+func (e *meterEntry) mark(now time.Time, n uint64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.tick(now)
+	e.count += n
+	e.uncounted += n
+}
+
+// tick updates the moving averages for every tick elapsed since the last one. e.mu must be held.
+func (e *meterEntry) tick(now time.Time) {
+	ticks := int64(now.Sub(e.lastTick) / meterTick)
+	if ticks <= 0 {
+		return
+	}
+	e.lastTick = e.lastTick.Add(time.Duration(ticks) * meterTick)
+	for _, a := range []*ewma{&e.m1, &e.m5, &e.m15} {
+		a.tick(e.uncounted, ticks-1)
+	}
+	e.uncounted = 0
+}
+
+// samples returns the count and rates of the meter.
+func (e *meterEntry) samples() []sample {
+	now := time.Now()
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.tick(now)
+
+	var mean float64
+	if elapsed := now.Sub(e.start).Seconds(); elapsed > 0 {
+		mean = float64(e.count) / elapsed
+	}
+	return []sample{
+		e.sample(meterKind, "count", e.count),
+		e.sample(meterKind, "mean_rate", mean),
+		e.sample(meterKind, "m1_rate", e.m1.rate),
+		e.sample(meterKind, "m5_rate", e.m5.rate),
+		e.sample(meterKind, "m15_rate", e.m15.rate),
+	}
+}
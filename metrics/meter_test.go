@@ -0,0 +1,28 @@
+package metrics
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestMeterEWMA(t *testing.T) {
+	start := time.Now()
+	e := newMeterEntry(seriesInfo{name: "foo", field: "value"}, start)
+	e.mark(start, 3)
+
+	e.tick(start.Add(meterTick))
+	for _, a := range []ewma{e.m1, e.m5, e.m15} {
+		if v, want := a.rate, 0.6; math.Abs(v-want) > 1e-9 {
+			t.Errorf("Rate was %v after one tick, but expected %v", v, want)
+		}
+	}
+
+	// a minute without events.
+	e.tick(start.Add(meterTick + time.Minute))
+	for i, want := range []float64{0.22072766, 0.49123845, 0.5613041} {
+		if v := []ewma{e.m1, e.m5, e.m15}[i].rate; math.Abs(v-want) > 1e-6 {
+			t.Errorf("Rate %d was %v after one minute, but expected %v", i, v, want)
+		}
+	}
+}
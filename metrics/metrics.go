@@ -4,147 +4,251 @@ import (
 	"bytes"
 	"sort"
 	"strconv"
-	"sync"
-
-	"github.com/codahale/metrics"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
-// Snapshot lock.
-var ss sync.Mutex
-
-// counterSeries is a specialized counter.
-// It is synonymous to influxdb series where series is composed of measurement name and tag key values.
-type counterSeries struct {
-	metrics.Counter
-}
-
-// newSeries provides counter name encoded in format of influxdb series: name,tags and field
-func newCounterSeries(name string, tags map[string]string, field string) counterSeries {
-	s := MakeSeries(name, tags, field)
-	return counterSeries{
-		metrics.Counter(s),
-	}
-}
-
-// Counter inherits metrics.Counter and provides counter in influxdb format.
+// Counter provides counter in influxdb format.
 // Use a counter to derive rates (e.g., record total number of requests, derive
 // requests per second).
 type Counter struct {
-	Name          string
-	Tags          map[string]string
-	Field         string
-	counterSeries // counter series.
+	Name     string
+	Tags     map[string]string
+	Field    string
+	registry *Registry
+	series   string // counter series.
 }
 
-// NewCounter returns new instance of Counter.
+// NewCounter returns new instance of Counter in the DefaultRegistry.
 func NewCounter(name string, tags map[string]string, field string) *Counter {
-	return &Counter{
-		Name:          name,
-		Tags:          tags,
-		Field:         field,
-		counterSeries: counterSeries(newCounterSeries(name, tags, field)),
-	}
+	return DefaultRegistry.NewCounter(name, tags, field)
 }
 
-// gaugeSeries is a specialized counter.
-// It is synonymous to influxdb series where series is composed of measurement name and tag key values.
-type gaugeSeries struct {
-	metrics.Gauge
+// Add increments the counter by one.
+func (c *Counter) Add() {
+	c.AddN(1)
 }
 
-// newGaugeSeries is new instance of GaugeSeries
-func newGaugeSeries(name string, tags map[string]string, field string) gaugeSeries {
-	s := MakeSeries(name, tags, field)
-	return gaugeSeries{
-		metrics.Gauge(s),
+// AddN increments the counter by delta.
+func (c *Counter) AddN(delta uint64) {
+	e := c.registry.counter(c.Name, c.Tags, c.Field, c.series)
+	if e == nil {
+		return
+	}
+	atomic.AddUint64(&e.value, delta)
+	if em := c.registry.currentEmitter(); em != nil {
+		em.Count(e.name, e.tags, e.field, delta)
 	}
 }
 
-// Gauge inherits metrics.Gauge and provides gauges in influxdb format.
+// SetFunc sets the counter's value to the lazily-called return value of the given function.
+func (c *Counter) SetFunc(f func() uint64) {
+	c.SetBatchFunc(nil, nil, f)
+}
+
+// SetBatchFunc sets the counter's value to the lazily-called return value of the given function,
+// with an additional initializer function for a related batch of counters, all of which are keyed
+// by an arbitrary value. init is called once per snapshot for each key.
+func (c *Counter) SetBatchFunc(key interface{}, init func(), f func() uint64) {
+	c.registry.setCounterFunc(c.Name, c.Tags, c.Field, c.series, key, init, f)
+}
+
+// Remove removes the counter.
+func (c *Counter) Remove() {
+	c.registry.removeCounter(c.series)
+}
+
+// Gauge provides gauges in influxdb format.
 // A Gauge is an instantaneous measurement of a value.
 //
 // Use a gauge to track metrics which increase and decrease (e.g., amount of
-// free memory).
+// free memory or number of requests in flight).
 type Gauge struct {
-	Name        string
-	Tags        map[string]string
-	Field       string
-	gaugeSeries // gauge series.
+	Name     string
+	Tags     map[string]string
+	Field    string
+	registry *Registry
+	series   string // gauge series.
 }
 
-// NewCounter returns new instance of Counter
+// NewGauge returns new instance of Gauge in the DefaultRegistry.
 func NewGauge(name string, tags map[string]string, field string) *Gauge {
-	return &Gauge{
-		Name:        name,
-		Tags:        tags,
-		Field:       field,
-		gaugeSeries: newGaugeSeries(name, tags, field),
+	return DefaultRegistry.NewGauge(name, tags, field)
+}
+
+// Set sets the gauge's value.
+func (g *Gauge) Set(value int64) {
+	e := g.registry.gauge(g.Name, g.Tags, g.Field, g.series)
+	if e == nil {
+		return
+	}
+	atomic.StoreInt64(&e.value, value)
+	if em := g.registry.currentEmitter(); em != nil {
+		em.Gauge(e.name, e.tags, e.field, value)
 	}
 }
 
-// A Histogram measures the distribution of a stream of values.
-// Use a histogram to track the distribution of a stream of values (e.g., the
-// latency associated with HTTP requests).
-type Histogram struct {
-	*metrics.Histogram
+// Inc increments the gauge by one.
+func (g *Gauge) Inc() {
+	g.Add(1)
+}
+
+// Dec decrements the gauge by one.
+func (g *Gauge) Dec() {
+	g.Add(-1)
+}
+
+// Sub decrements the gauge by delta.
+func (g *Gauge) Sub(delta int64) {
+	g.Add(-delta)
 }
 
-func NewHistogram(name string, tags map[string]string, field string, minValue, maxValue int64) *Histogram {
-	s := MakeSeries(name, tags, field)
-	return &Histogram{
-		metrics.NewHistogram(s, minValue, maxValue, 3),
+// Add atomically adds delta, which may be negative, to the gauge's value. Use Add instead of Set when several
+// goroutines adjust a gauge, e.g. to track the number of open connections. A gauge set by SetFunc starts from zero.
+func (g *Gauge) Add(delta int64) {
+	e := g.registry.gauge(g.Name, g.Tags, g.Field, g.series)
+	if e == nil {
+		return
+	}
+	value := atomic.AddInt64(&e.value, delta)
+	if em := g.registry.currentEmitter(); em != nil {
+		em.Gauge(e.name, e.tags, e.field, value)
 	}
 }
 
-// SnapshotLines provies all collected metrics in Line protocol format. https://github.com/influxdata/influxdb/blob/master/tsdb/README.md
+// SetFunc sets the gauge's value to the lazily-called return value of the given function.
+func (g *Gauge) SetFunc(f func() int64) {
+	g.SetBatchFunc(nil, nil, f)
+}
+
+// SetBatchFunc sets the gauge's value to the lazily-called return value of the given function,
+// with an additional initializer function for a related batch of gauges, all of which are keyed
+// by an arbitrary value. init is called once per snapshot for each key.
+func (g *Gauge) SetBatchFunc(key interface{}, init func(), f func() int64) {
+	g.registry.setGaugeFunc(g.Name, g.Tags, g.Field, g.series, key, init, f)
+}
+
+// Remove removes the gauge.
+func (g *Gauge) Remove() {
+	g.registry.removeGauge(g.series)
+}
+
+// LineOptions configures the Line protocol output of SnapshotLinesWithOptions.
+type LineOptions struct {
+	// Timestamp is appended to every line in units of Precision since epoch. No timestamp is written when it is zero
+	// and the server assigns its own time instead.
+	Timestamp time.Time
+
+	// Precision of the timestamp: time.Nanosecond (default), time.Microsecond, time.Millisecond or time.Second.
+	Precision time.Duration
+
+	// Group writes all fields sharing the same measurement and tag set as a single multi-field point:
+	// <measurement>,<tag1>=<key1> <field1>=<value1>i,<field2>=<value2>i [timestamp]
+	Group bool
+}
+
+// SnapshotLines provies all collected metrics of the DefaultRegistry in Line protocol format. https://github.com/influxdata/influxdb/blob/master/tsdb/README.md
 func SnapshotLines() string {
-	ss.Lock()
-	defer ss.Unlock()
-	var buffer bytes.Buffer
-	counters, gauges := metrics.Snapshot()
-	for c, v := range counters {
-		buffer.WriteString(c)
-		buffer.WriteString("=")
-		buffer.Write([]byte(strconv.FormatUint(v, 10)))
-		buffer.Write([]byte("\n"))
+	return DefaultRegistry.SnapshotLines()
+}
+
+// SnapshotLinesWithOptions provides all collected metrics of the DefaultRegistry in Line protocol format configured by opts.
+func SnapshotLinesWithOptions(opts LineOptions) string {
+	return DefaultRegistry.SnapshotLinesWithOptions(opts)
+}
+
+// SnapshotLines provies all collected metrics in Line protocol format. https://github.com/influxdata/influxdb/blob/master/tsdb/README.md
+func (r *Registry) SnapshotLines() string {
+	return r.SnapshotLinesWithOptions(LineOptions{})
+}
+
+// SnapshotLinesWithOptions provides all collected metrics in Line protocol format configured by opts.
+// Integer values are written with the "i" suffix: <measurement>,<tag1>=<key1> <field1>=<value>i [timestamp]
+// Floating point values such as the mean of a histogram are written without a suffix.
+func (r *Registry) SnapshotLinesWithOptions(opts LineOptions) string {
+	var ts string
+	if !opts.Timestamp.IsZero() {
+		precision := opts.Precision
+		if precision <= 0 {
+			precision = time.Nanosecond
+		}
+		ts = " " + strconv.FormatInt(opts.Timestamp.UnixNano()/int64(precision), 10)
 	}
 
-	for g, v := range gauges {
-		buffer.WriteString(g)
-		buffer.WriteString("=")
-		buffer.Write([]byte(strconv.FormatInt(v, 10)))
-		buffer.Write([]byte("\n"))
+	// points keeps series in the order they were first seen, fields holds the fields of each series.
+	var points []string
+	fields := make(map[string][]string)
+	for _, s := range r.collect() {
+		key := makeSeriesKey(s.measurement, s.tags)
+		field := keyEscaper.Replace(s.fieldKey()) + "=" + formatValue(s.value)
+		if _, ok := s.value.(float64); !ok {
+			field += "i"
+		}
+		if !opts.Group {
+			points = append(points, key+" "+field)
+			continue
+		}
+		if _, ok := fields[key]; !ok {
+			points = append(points, key)
+		}
+		fields[key] = append(fields[key], field)
+	}
+
+	var buffer bytes.Buffer
+	for _, p := range points {
+		buffer.WriteString(p)
+		if opts.Group {
+			buffer.WriteString(" ")
+			buffer.WriteString(strings.Join(fields[p], ","))
+		}
+		buffer.WriteString(ts)
+		buffer.WriteString("\n")
 	}
 	return buffer.String()
 }
 
-// Snapshot provides all collected metrics.
+// Snapshot provides all collected metrics of the DefaultRegistry.
 func Snapshot() (c map[string]uint64, g map[string]int64) {
-	ss.Lock()
-	defer ss.Unlock()
-	return metrics.Snapshot()
+	return DefaultRegistry.Snapshot()
 }
 
-// TODO: provide snapshot in json format.
-// func SnapshotJson() []byte {
-// }
-
-// Reset clears all counters and gauges.
+// Reset clears all counters, gauges, histograms, meters and timers of the DefaultRegistry.
 func Reset() {
-	metrics.Reset()
+	DefaultRegistry.Reset()
 }
 
 //MakeSeries creates Series in influxdb format: <measurement>,<tag1>=<key1>,<tagN>=<keyN) <field1>=
+// Commas and spaces in the measurement and commas, equal signs and spaces in tag keys, tag values and
+// field are escaped with a backslash as required by the Line protocol.
 func MakeSeries(name string, tags map[string]string, field string) string {
+	return makeSeriesKey(name, tags) + " " + keyEscaper.Replace(field)
+}
+
+// makeSeriesKey creates the escaped measurement and tag set part of a series: <measurement>,<tag1>=<key1>,<tagN>=<keyN>
+// Tags with an empty value are left out as InfluxDB rejects them.
+func makeSeriesKey(name string, tags map[string]string) string {
+	s := measurementEscaper.Replace(name)
+	for _, k := range sortedKeys(tags) {
+		if tags[k] == "" {
+			continue
+		}
+		s = s + "," + keyEscaper.Replace(k) + "=" + keyEscaper.Replace(tags[k])
+	}
+	return s
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+// sortedKeys returns tag keys in sorted order.
+func sortedKeys(tags map[string]string) []string {
 	var keys []string
 	for k, _ := range tags {
 		keys = append(keys, k)
 	}
 	sort.Strings(keys)
-
-	s := name
-	for _, k := range keys {
-		s = s + "," + k + "=" + tags[k]
-	}
-	return s + " " + field
+	return keys
 }
@@ -1,8 +1,12 @@
 package metrics_test
 
 import (
+	"encoding/json"
+	"math"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/supershal/stats/metrics"
 )
@@ -21,7 +25,7 @@ func TestCounter(t *testing.T) {
 	c.AddN(10)
 
 	lines := metrics.SnapshotLines()
-	if v, want := lines, "foo,bar=baz,qux=quux value=11\n"; v != want {
+	if v, want := lines, "foo,bar=baz,qux=quux value=11i\n"; v != want {
 		t.Errorf("Counter was %v, but expected %v", v, want)
 	}
 }
@@ -39,7 +43,7 @@ func TestCounterFunc(t *testing.T) {
 	})
 
 	lines := metrics.SnapshotLines()
-	if v, want := lines, "foo,bar=baz value=100\n"; v != want {
+	if v, want := lines, "foo,bar=baz value=100i\n"; v != want {
 		t.Errorf("Counter was %v, but expected %v", v, want)
 	}
 }
@@ -84,11 +88,11 @@ func TestCounterBatchFunc(t *testing.T) {
 	)
 
 	lines := metrics.SnapshotLines()
-	if v, want := lines, "foo,bar=baz,qux=quux value=1\n"; !strings.Contains(v, want) {
+	if v, want := lines, "foo,bar=baz,qux=quux value=1i\n"; !strings.Contains(v, want) {
 		t.Errorf("Counter was %v, but expected %v", v, want)
 	}
 
-	if v, want := lines, "foo1,bar1=baz1 value1=2\n"; !strings.Contains(v, want) {
+	if v, want := lines, "foo1,bar1=baz1 value1=2i\n"; !strings.Contains(v, want) {
 		t.Errorf("Counter was %v, but expected %v", v, want)
 	}
 }
@@ -126,7 +130,36 @@ func TestGaugeValue(t *testing.T) {
 	g.Set(-100)
 
 	lines := metrics.SnapshotLines()
-	if v, want := lines, "foo,bar=baz,qux=quux value=-100\n"; v != want {
+	if v, want := lines, "foo,bar=baz,qux=quux value=-100i\n"; v != want {
+		t.Errorf("Gauge was %v, but expected %v", v, want)
+	}
+}
+
+func TestGaugeAdd(t *testing.T) {
+	r := metrics.NewRegistry()
+
+	g := r.NewGauge("pool", map[string]string{"name": "db"}, "open")
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			g.Inc()
+			g.Add(3)
+			g.Sub(2)
+			g.Dec()
+			g.Inc()
+		}()
+	}
+	wg.Wait()
+
+	if v, want := r.SnapshotLines(), "pool,name=db open=100i\n"; v != want {
+		t.Errorf("Gauge was %v, but expected %v", v, want)
+	}
+
+	g.Set(-3)
+	g.Dec()
+	if v, want := r.SnapshotLines(), "pool,name=db open=-4i\n"; v != want {
 		t.Errorf("Gauge was %v, but expected %v", v, want)
 	}
 }
@@ -147,7 +180,7 @@ func TestGaugeFunc(t *testing.T) {
 	})
 
 	lines := metrics.SnapshotLines()
-	if v, want := lines, "foo,bar=baz,qux=quux value=-100\n"; v != want {
+	if v, want := lines, "foo,bar=baz,qux=quux value=-100i\n"; v != want {
 		t.Errorf("Gauge was %v, but expected %v", v, want)
 	}
 }
@@ -188,31 +221,107 @@ func TestHistogram(t *testing.T) {
 
 	lines := metrics.SnapshotLines()
 
-	if v, want := lines, "foo,bar=baz latency.P50=71"; !strings.Contains(v, want) {
+	if v, want := lines, "foo,bar=baz latency.P50=71i"; !strings.Contains(v, want) {
 		t.Errorf("P50 was %v, but expected %v", v, want)
 	}
 
-	if v, want := lines, "foo,bar=baz latency.P75=87"; !strings.Contains(v, want) {
+	if v, want := lines, "foo,bar=baz latency.P75=87i"; !strings.Contains(v, want) {
 		t.Errorf("P75 was %v, but expected %v", v, want)
 	}
 
-	if v, want := lines, "foo,bar=baz latency.P90=95"; !strings.Contains(v, want) {
+	if v, want := lines, "foo,bar=baz latency.P90=95i"; !strings.Contains(v, want) {
 		t.Errorf("P90 was %v, but expected %v", v, want)
 	}
 
-	if v, want := lines, "foo,bar=baz latency.P95=98"; !strings.Contains(v, want) {
+	if v, want := lines, "foo,bar=baz latency.P95=98i"; !strings.Contains(v, want) {
 		t.Errorf("P95 was %v, but expected %v", v, want)
 	}
 
-	if v, want := lines, "foo,bar=baz latency.P99=100"; !strings.Contains(v, want) {
+	if v, want := lines, "foo,bar=baz latency.P99=100i"; !strings.Contains(v, want) {
 		t.Errorf("P99 was %v, but expected %v", v, want)
 	}
 
-	if v, want := lines, "foo,bar=baz latency.P99=100"; !strings.Contains(v, want) {
+	if v, want := lines, "foo,bar=baz latency.P99=100i"; !strings.Contains(v, want) {
 		t.Errorf("P999 was %v, but expected %v", v, want)
 	}
 }
 
+func TestHistogramWithOptions(t *testing.T) {
+	r := metrics.NewRegistry()
+
+	h := r.NewHistogramWithOptions("foo", map[string]string{"bar": "baz"}, "latency", metrics.HistogramOptions{
+		Min:       1,
+		Max:       100000,
+		SigFigs:   5,
+		Quantiles: []float64{99.99, 50},
+	})
+	for i := 1; i <= 10000; i++ {
+		h.RecordValue(int64(i))
+	}
+
+	if v, want := r.SnapshotLines(), "foo,bar=baz latency.P50=5000i\nfoo,bar=baz latency.P9999=9999i\nfoo,bar=baz latency.count="; !strings.HasPrefix(v, want) {
+		t.Errorf("Histogram was %v, but expected %v", v, want)
+	}
+}
+
+func TestHistogramStats(t *testing.T) {
+	r := metrics.NewRegistry()
+
+	h := r.NewHistogram("foo", nil, "latency", 1, 1000)
+	for _, v := range []int64{2, 4, 4, 4, 5, 5, 7, 9} {
+		h.RecordValue(v)
+	}
+
+	c, g := r.Snapshot()
+	if v, want := c["foo latency.count"], uint64(8); v != want {
+		t.Errorf("Count was %v, but expected %v", v, want)
+	}
+	for stat, want := range map[string]int64{"sum": 40, "min": 2, "max": 9, "mean": 5, "stddev": 2} {
+		if v := g["foo latency."+stat]; v != want {
+			t.Errorf("%v was %v, but expected %v", stat, v, want)
+		}
+	}
+
+	// snapshots do not drop values from the default five minute window.
+	for i := 0; i < 10; i++ {
+		r.SnapshotLines()
+		if c, _ = r.Snapshot(); c["foo latency.count"] != 8 {
+			t.Errorf("Count was %v after %d snapshots, but expected 8", c["foo latency.count"], 2*i+3)
+		}
+	}
+}
+
+func TestHistogramWindow(t *testing.T) {
+	t.Parallel()
+	r := metrics.NewRegistry()
+
+	h := r.NewHistogramWithOptions("foo", nil, "latency", metrics.HistogramOptions{
+		Min:     1,
+		Max:     1000,
+		Window:  200 * time.Millisecond,
+		Buckets: 2,
+	})
+	h.RecordValue(10)
+
+	// snapshots do not rotate a histogram.
+	for i := 0; i < 10; i++ {
+		if c, _ := r.Snapshot(); c["foo latency.count"] != 1 {
+			t.Fatalf("Count was %v after %d snapshots, but expected 1", c["foo latency.count"], i+1)
+		}
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	h.RecordValue(20)
+	if c, _ := r.Snapshot(); c["foo latency.count"] == 0 {
+		t.Errorf("Count was 0, but expected the value recorded in the last %v", 200*time.Millisecond)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	if c, _ := r.Snapshot(); c["foo latency.count"] != 0 {
+		t.Errorf("Count was %v, but expected values older than the window to be dropped", c["foo latency.count"])
+	}
+}
+
 func TestHistogramRemove(t *testing.T) {
 	metrics.Reset()
 
@@ -232,6 +341,596 @@ func TestHistogramRemove(t *testing.T) {
 	}
 }
 
+func TestHistogramAfterReset(t *testing.T) {
+	t.Parallel()
+	r := metrics.NewRegistry()
+
+	h := r.NewHistogram("foo", nil, "latency", 1, 1000)
+	tm := r.NewTimer("foo", nil, "query")
+	r.Reset()
+	h.RecordValue(10)
+	tm.Update(20 * time.Millisecond)
+
+	c, _ := r.Snapshot()
+	if v := c["foo latency.count"]; v != 1 {
+		t.Errorf("Histogram count was %v after Reset, but expected 1", v)
+	}
+	if v := c["foo query.count"]; v != 1 {
+		t.Errorf("Timer count was %v after Reset, but expected 1", v)
+	}
+
+	h.Remove()
+	h.RecordValue(10)
+	if c, _ = r.Snapshot(); c["foo latency.count"] != 1 {
+		t.Errorf("Histogram count was %v after Remove, but expected 1", c["foo latency.count"])
+	}
+}
+
+func TestFloatCounter(t *testing.T) {
+	r := metrics.NewRegistry()
+
+	c := r.NewFloatCounter("gc", nil, "seconds")
+	c.Add(0.25)
+	c.Add(1.5)
+	c.Add(-1)
+	r.NewFloatCounter("gc", nil, "cpu").SetFunc(func() float64 { return 0.125 })
+
+	if v, want := r.SnapshotLines(), "gc cpu=0.125\ngc seconds=1.75\n"; v != want {
+		t.Errorf("Lines were %v, but expected %v", v, want)
+	}
+	if counters, _ := r.Snapshot(); counters["gc seconds"] != 2 {
+		t.Errorf("Counter was %v, but expected 2", counters["gc seconds"])
+	}
+
+	c.Remove()
+	if v, want := r.SnapshotLines(), "gc cpu=0.125\n"; v != want {
+		t.Errorf("Lines were %v, but expected %v", v, want)
+	}
+}
+
+func TestFloatGauge(t *testing.T) {
+	r := metrics.NewRegistry()
+
+	g := r.NewFloatGauge("cache", map[string]string{"name": "users"}, "hit_ratio")
+	g.Set(0.5)
+	g.Add(0.25)
+	g.Add(-0.5)
+
+	if v, want := r.SnapshotLines(), "cache,name=users hit_ratio=0.25\n"; v != want {
+		t.Errorf("Lines were %v, but expected %v", v, want)
+	}
+
+	g.SetFunc(func() float64 { return 2.5 })
+	if v, want := r.SnapshotLines(), "cache,name=users hit_ratio=2.5\n"; v != want {
+		t.Errorf("Lines were %v, but expected %v", v, want)
+	}
+
+	b, err := r.SnapshotJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, want := string(b), `"field":"hit_ratio","value":2.5}`; !strings.Contains(v, want) {
+		t.Errorf("JSON was %v, but expected %v", v, want)
+	}
+
+	if v, want := r.SnapshotPrometheus(), "cache_hit_ratio{name=\"users\"} 2.5\n"; !strings.Contains(v, want) {
+		t.Errorf("Prometheus output was %v, but expected %v", v, want)
+	}
+}
+
+func TestFloatNonFinite(t *testing.T) {
+	t.Parallel()
+	r := metrics.NewRegistry()
+
+	r.NewFloatGauge("cache", nil, "ratio").Set(math.NaN())
+	r.NewFloatGauge("cache", nil, "load").Set(math.Inf(-1))
+	r.NewFloatCounter("cache", nil, "seconds").Add(math.Inf(1))
+	r.NewFloatGauge("cache", nil, "size").Set(1.5)
+
+	if v, want := r.SnapshotLines(), "cache size=1.5\n"; v != want {
+		t.Errorf("Lines were %v, but expected %v", v, want)
+	}
+	b, err := r.SnapshotJSON()
+	if err != nil {
+		t.Fatalf("SnapshotJSON failed: %v", err)
+	}
+	if v := string(b); strings.Contains(v, "ratio") || !strings.Contains(v, `"field":"size","value":1.5`) {
+		t.Errorf("JSON was %v, but expected only the size gauge", v)
+	}
+	for _, out := range []string{r.SnapshotGraphite(time.Unix(0, 0)), r.SnapshotPrometheus()} {
+		if strings.Contains(out, "NaN") || strings.Contains(out, "Inf") {
+			t.Errorf("Output was %v, but expected no non-finite values", out)
+		}
+	}
+}
+
+func TestMeter(t *testing.T) {
+	r := metrics.NewRegistry()
+
+	m := r.NewMeter("foo", map[string]string{"bar": "baz"}, "throughput")
+	m.Mark()
+	m.MarkN(4)
+
+	c, g := r.Snapshot()
+	if v, want := c["foo,bar=baz throughput.count"], uint64(5); v != want {
+		t.Errorf("Count was %v, but expected %v", v, want)
+	}
+	for _, rate := range []string{"mean_rate", "m1_rate", "m5_rate", "m15_rate"} {
+		if _, ok := g["foo,bar=baz throughput."+rate]; !ok {
+			t.Errorf("Gauges were %v, but expected %v", g, rate)
+		}
+	}
+
+	lines := r.SnapshotLines()
+	if v, want := lines, "foo,bar=baz throughput.count=5i\nfoo,bar=baz throughput.mean_rate="; !strings.HasPrefix(v, want) {
+		t.Errorf("Lines were %v, but expected %v", v, want)
+	}
+	if v, want := lines, "foo,bar=baz throughput.m1_rate=0\n"; !strings.Contains(v, want) {
+		t.Errorf("Lines were %v, but expected %v before the first tick", v, want)
+	}
+
+	m.Remove()
+	if v := r.SnapshotLines(); v != "" {
+		t.Errorf("Lines were %v, but expected nothing", v)
+	}
+}
+
+func TestTimer(t *testing.T) {
+	r := metrics.NewRegistry()
+
+	tm := r.NewTimer("db", map[string]string{"query": "select"}, "duration")
+	tm.Update(40 * time.Millisecond)
+	tm.UpdateSince(time.Now().Add(-20 * time.Millisecond))
+	tm.Time(func() {})
+	if d := tm.Start().Stop(); d < 0 {
+		t.Errorf("Duration was %v, but expected a positive duration", d)
+	}
+
+	c, g := r.Snapshot()
+	if v, want := c["db,query=select duration.count"], uint64(4); v != want {
+		t.Errorf("Count was %v, but expected %v", v, want)
+	}
+	if v, want := g["db,query=select duration.max"], int64(40); v != want {
+		t.Errorf("Max was %v, but expected %v", v, want)
+	}
+	if v := g["db,query=select duration.sum"]; v < 60 || v > 70 {
+		t.Errorf("Sum was %v, but expected 60ms to 70ms", v)
+	}
+	for _, rate := range []string{"mean_rate", "m1_rate", "m5_rate", "m15_rate"} {
+		if _, ok := g["db,query=select duration."+rate]; !ok {
+			t.Errorf("Gauges were %v, but expected %v", g, rate)
+		}
+	}
+	if n := strings.Count(r.SnapshotLines(), "duration.count="); n != 1 {
+		t.Errorf("Count was reported %d times, but expected once", n)
+	}
+
+	tm.Remove()
+	if v := r.SnapshotLines(); v != "" {
+		t.Errorf("Lines were %v, but expected nothing", v)
+	}
+}
+
+func TestTimerUnit(t *testing.T) {
+	r := metrics.NewRegistry()
+
+	tm := r.NewTimerWithOptions("db", nil, "duration", metrics.TimerOptions{Unit: time.Microsecond})
+	tm.Update(1500 * time.Microsecond)
+
+	// a timer of an existing series keeps the unit it was created with.
+	r.NewTimer("db", nil, "duration").Update(500 * time.Microsecond)
+
+	_, g := r.Snapshot()
+	if v, want := g["db duration.sum"], int64(2000); v != want {
+		t.Errorf("Sum was %v, but expected %v", v, want)
+	}
+}
+
+func TestSnapshotLinesEscaping(t *testing.T) {
+	metrics.Reset()
+
+	c := metrics.NewCounter(
+		"http request,v2",
+		map[string]string{
+			"uri":     "/search?q=a b,c",
+			"tag key": "value",
+		},
+		"field=key")
+	c.Add()
+
+	lines := metrics.SnapshotLines()
+	if v, want := lines, `http\ request\,v2,tag\ key=value,uri=/search?q\=a\ b\,c field\=key=1i`+"\n"; v != want {
+		t.Errorf("Counter was %v, but expected %v", v, want)
+	}
+
+	b, _ := metrics.SnapshotJSON()
+	if v, want := string(b), `"tags":{"tag key":"value","uri":"/search?q=a b,c"},"field":"field=key"`; !strings.Contains(v, want) {
+		t.Errorf("JSON was %v, but expected unescaped series %v", v, want)
+	}
+}
+
+func TestSnapshotEmptyTagValue(t *testing.T) {
+	t.Parallel()
+	r := metrics.NewRegistry()
+
+	r.NewCounter("foo", map[string]string{"host": "", "bar": "baz"}, "value").Add()
+
+	if v, want := r.SnapshotLines(), "foo,bar=baz value=1i\n"; v != want {
+		t.Errorf("Lines were %v, but expected %v", v, want)
+	}
+	if v, want := r.SnapshotGraphite(time.Unix(1465839830, 0)), "foo.bar.baz.value 1 1465839830\n"; v != want {
+		t.Errorf("Graphite was %v, but expected %v", v, want)
+	}
+}
+
+func TestSnapshotLinesTimestamp(t *testing.T) {
+	metrics.Reset()
+
+	metrics.NewGauge("foo", map[string]string{"bar": "baz"}, "value").Set(1)
+
+	lines := metrics.SnapshotLinesWithOptions(metrics.LineOptions{
+		Timestamp: time.Unix(1465839830, 100),
+	})
+	if v, want := lines, "foo,bar=baz value=1i 1465839830000000100\n"; v != want {
+		t.Errorf("Gauge was %v, but expected %v", v, want)
+	}
+}
+
+func TestSnapshotLinesGroup(t *testing.T) {
+	metrics.Reset()
+
+	tags := map[string]string{
+		"bar": "baz",
+	}
+	metrics.NewCounter("foo", tags, "200").AddN(5)
+	metrics.NewCounter("foo", tags, "total").AddN(5)
+	metrics.NewGauge("foo", tags, "size").Set(3)
+	metrics.NewGauge("foo", map[string]string{"bar": "qux"}, "size").Set(4)
+
+	lines := metrics.SnapshotLinesWithOptions(metrics.LineOptions{
+		Group:     true,
+		Timestamp: time.Unix(1, 0),
+	})
+	points := strings.Split(strings.TrimSuffix(lines, "\n"), "\n")
+	if len(points) != 2 {
+		t.Fatalf("Lines were %v, but expected 2 points", lines)
+	}
+
+	for _, p := range points {
+		switch {
+		case strings.HasPrefix(p, "foo,bar=baz "):
+			for _, want := range []string{"200=5i", "total=5i", "size=3i"} {
+				if !strings.Contains(p, want) {
+					t.Errorf("Point was %v, but expected field %v", p, want)
+				}
+			}
+			if n := strings.Count(p, ","); n != 3 {
+				t.Errorf("Point was %v, but expected 3 fields", p)
+			}
+		case p != "foo,bar=qux size=4i 1000000000":
+			t.Errorf("Point was %v, but expected foo,bar=qux size=4i 1000000000", p)
+		}
+		if !strings.HasSuffix(p, " 1000000000") {
+			t.Errorf("Point was %v, but expected timestamp 1000000000", p)
+		}
+	}
+}
+
+func TestSnapshotOrder(t *testing.T) {
+	metrics.Reset()
+
+	metrics.NewCounter("b", map[string]string{"host": "a"}, "total").Add()
+	metrics.NewGauge("a", map[string]string{"host": "b"}, "size").Set(1)
+	metrics.NewCounter("a", map[string]string{"host": "b"}, "200").Add()
+	metrics.NewGauge("a", map[string]string{"host": "a"}, "size").Set(1)
+	metrics.NewCounter("a", nil, "total").Add()
+	metrics.NewHistogram("a", map[string]string{"host": "a"}, "latency", 1, 1000)
+
+	want := strings.Join([]string{
+		"a total=1i",
+		"a,host=a latency.P50=0i",
+		"a,host=a latency.P75=0i",
+		"a,host=a latency.P90=0i",
+		"a,host=a latency.P95=0i",
+		"a,host=a latency.P99=0i",
+		"a,host=a latency.P999=0i",
+		"a,host=a latency.count=0i",
+		"a,host=a latency.sum=0i",
+		"a,host=a latency.min=0i",
+		"a,host=a latency.max=0i",
+		"a,host=a latency.mean=0",
+		"a,host=a latency.stddev=0",
+		"a,host=a size=1i",
+		"a,host=b 200=1i",
+		"a,host=b size=1i",
+		"b,host=a total=1i",
+	}, "\n") + "\n"
+
+	for i := 0; i < 10; i++ {
+		if v := metrics.SnapshotLines(); v != want {
+			t.Fatalf("Lines were %v, but expected %v", v, want)
+		}
+	}
+}
+
+func TestRegistryIsolation(t *testing.T) {
+	t.Parallel()
+	a, b := metrics.NewRegistry(), metrics.NewRegistry()
+
+	a.NewCounter("foo", nil, "value").AddN(2)
+	b.NewCounter("foo", nil, "value").Add()
+	b.NewGauge("bar", nil, "value").Set(3)
+
+	if v, want := a.SnapshotLines(), "foo value=2i\n"; v != want {
+		t.Errorf("Registry a was %v, but expected %v", v, want)
+	}
+	if v, want := b.SnapshotLines(), "bar value=3i\nfoo value=1i\n"; v != want {
+		t.Errorf("Registry b was %v, but expected %v", v, want)
+	}
+
+	a.Reset()
+	if v := a.SnapshotLines(); v != "" {
+		t.Errorf("Registry a was %v, but expected nothing after Reset", v)
+	}
+	if v, want := b.SnapshotLines(), "bar value=3i\nfoo value=1i\n"; v != want {
+		t.Errorf("Registry b was %v after resetting a, but expected %v", v, want)
+	}
+}
+
+func TestRegistryLimitsFold(t *testing.T) {
+	t.Parallel()
+	r := metrics.NewRegistry()
+	r.SetLimits(metrics.Limits{MaxSeriesPerMeasurement: 2})
+
+	for _, user := range []string{"a", "b", "c", "d"} {
+		r.NewCounter("requests", map[string]string{"user": user}, "count").Add()
+		r.NewCounter("requests", map[string]string{"user": user}, "errors").Add()
+	}
+	r.NewHistogram("latency", map[string]string{"user": "a"}, "value", 0, 100)
+	r.NewHistogram("latency", map[string]string{"user": "b"}, "value", 0, 100)
+	r.NewHistogram("latency", map[string]string{"user": "c"}, "value", 0, 100).RecordValue(1)
+
+	c, g := r.Snapshot()
+	want := map[string]uint64{
+		"requests,user=a count":                        1,
+		"requests,user=a errors":                       1,
+		"requests,user=b count":                        1,
+		"requests,user=b errors":                       1,
+		"requests,user=other count":                    2,
+		"requests,user=other errors":                   2,
+		"metrics,measurement=requests series_overflow": 4,
+		"metrics,measurement=latency series_overflow":  1,
+		"latency,user=a value.count":                   0,
+		"latency,user=b value.count":                   0,
+		"latency,user=other value.count":               1,
+	}
+	for k, v := range want {
+		if c[k] != v {
+			t.Errorf("Counter %v was %v, but expected %v", k, c[k], v)
+		}
+	}
+	if len(c) != len(want) {
+		t.Errorf("Counters were %v, but expected %v", c, want)
+	}
+	if _, ok := g["latency,user=other value.P50"]; !ok {
+		t.Errorf("Gauges were %v, but expected the other latency histogram", g)
+	}
+
+	// every update of a folded series is counted, not only the first.
+	for i := 0; i < 3; i++ {
+		r.NewCounter("requests", map[string]string{"user": "d"}, "count").Add()
+	}
+	c, _ = r.Snapshot()
+	if v := c["requests,user=other count"]; v != 5 {
+		t.Errorf("Counter requests,user=other count was %v, but expected 5", v)
+	}
+	if v := c["metrics,measurement=requests series_overflow"]; v != 7 {
+		t.Errorf("Counter series_overflow was %v, but expected 7", v)
+	}
+}
+
+func TestRegistryLimitsDrop(t *testing.T) {
+	t.Parallel()
+	r := metrics.NewRegistry()
+	r.SetLimits(metrics.Limits{MaxSeries: 2, Drop: true})
+
+	r.NewGauge("a", nil, "value").Set(1)
+	r.NewGauge("b", map[string]string{"x": "1"}, "value").Set(1)
+	r.NewGauge("b", map[string]string{"x": "2"}, "value").Set(1)
+	r.NewCounter("c", nil, "value").SetFunc(func() uint64 { return 1 })
+	r.NewHistogram("d", nil, "value", 0, 100).RecordValue(1)
+
+	if v, want := r.SnapshotLines(), "a value=1i\nb,x=1 value=1i\nmetrics,measurement=b series_overflow=1i\nmetrics,measurement=c series_overflow=1i\nmetrics,measurement=d series_overflow=1i\n"; v != want {
+		t.Errorf("Lines were %v, but expected %v", v, want)
+	}
+
+	// removing a series makes room for a new one.
+	r.NewGauge("a", nil, "value").Remove()
+	r.NewGauge("b", map[string]string{"x": "2"}, "value").Set(2)
+	if v := r.SnapshotLines(); !strings.Contains(v, "b,x=2 value=2i\n") {
+		t.Errorf("Lines were %v, but expected b,x=2 value=2i", v)
+	}
+}
+
+func TestSnapshotJSON(t *testing.T) {
+	metrics.Reset()
+
+	tags := map[string]string{
+		"bar": "baz",
+	}
+	metrics.NewCounter("foo", tags, "count").AddN(3)
+	metrics.NewGauge("foo", tags, "size").Set(-7)
+	h := metrics.NewHistogram("foo", tags, "latency", 1, 1000)
+	h.RecordValue(10)
+
+	b, err := metrics.SnapshotJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc struct {
+		Counters []struct {
+			Measurement string
+			Tags        map[string]string
+			Field       string
+			Value       uint64
+		}
+		Gauges []struct {
+			Measurement string
+			Tags        map[string]string
+			Field       string
+			Value       int64
+		}
+		Histograms []struct {
+			Measurement string
+			Tags        map[string]string
+			Field       string
+			Values      map[string]int64
+		}
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatalf("SnapshotJSON returned invalid JSON %s: %v", b, err)
+	}
+
+	if len(doc.Counters) != 1 || len(doc.Gauges) != 1 || len(doc.Histograms) != 1 {
+		t.Fatalf("Snapshot was %s, but expected one counter, gauge and histogram", b)
+	}
+
+	c := doc.Counters[0]
+	if c.Measurement != "foo" || c.Tags["bar"] != "baz" || c.Field != "count" || c.Value != 3 {
+		t.Errorf("Counter was %+v, but expected foo,bar=baz count=3", c)
+	}
+
+	g := doc.Gauges[0]
+	if g.Measurement != "foo" || g.Tags["bar"] != "baz" || g.Field != "size" || g.Value != -7 {
+		t.Errorf("Gauge was %+v, but expected foo,bar=baz size=-7", g)
+	}
+
+	hist := doc.Histograms[0]
+	if hist.Measurement != "foo" || hist.Tags["bar"] != "baz" || hist.Field != "latency" {
+		t.Errorf("Histogram was %+v, but expected foo,bar=baz latency", hist)
+	}
+	for _, p := range []string{"P50", "P75", "P90", "P95", "P99", "P999"} {
+		if v, ok := hist.Values[p]; !ok || v != 10 {
+			t.Errorf("Histogram %v was %v, but expected 10", p, v)
+		}
+	}
+}
+
+func TestSnapshotGraphite(t *testing.T) {
+	metrics.Reset()
+
+	metrics.NewCounter(
+		"foo",
+		map[string]string{
+			"qux":  "quux",
+			"bar":  "baz",
+			"host": "web1.example.com",
+		},
+		"value").AddN(11)
+
+	lines := metrics.SnapshotGraphite(time.Unix(1465839830, 0))
+	if v, want := lines, "foo.bar.baz.host.web1_example_com.qux.quux.value 11 1465839830\n"; v != want {
+		t.Errorf("Graphite was %v, but expected %v", v, want)
+	}
+}
+
+func TestMakeGraphitePath(t *testing.T) {
+	p := metrics.MakeGraphitePath("http response", map[string]string{"uri": "/users/1"}, "latency.P99")
+	if want := "http_response.uri._users_1.latency_P99"; p != want {
+		t.Errorf("Path was %v, but expected %v", p, want)
+	}
+}
+
+func TestSnapshotPrometheus(t *testing.T) {
+	metrics.Reset()
+
+	tags := map[string]string{
+		"host": "web-1",
+	}
+	metrics.NewCounter("http_response", tags, "200").AddN(5)
+	metrics.NewGauge("http_response", tags, "size").Set(42)
+	h := metrics.NewHistogram("http_response", tags, "latency", 1, 1000)
+	h.RecordValue(10)
+
+	out := metrics.SnapshotPrometheus()
+	for _, want := range []string{
+		"# TYPE http_response counter\n",
+		`http_response{field="200",host="web-1"} 5`,
+		"# TYPE http_response_size gauge\n",
+		`http_response_size{host="web-1"} 42`,
+		"# TYPE http_response_latency summary\n",
+		`http_response_latency{host="web-1",quantile="0.5"} 10`,
+		`http_response_latency{host="web-1",quantile="0.99"} 10`,
+		`http_response_latency{host="web-1",quantile="0.999"} 10`,
+		`http_response_latency_sum{host="web-1"} 10`,
+		`http_response_latency_count{host="web-1"} 1`,
+		"# TYPE http_response_latency_max gauge\n",
+		`http_response_latency_max{host="web-1"} 10`,
+		`http_response_latency_mean{host="web-1"} 10`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Prometheus output was %v, but expected it to contain %v", out, want)
+		}
+	}
+
+	if n := strings.Count(out, "# TYPE http_response_latency summary"); n != 1 {
+		t.Errorf("Summary type was declared %d times, but expected once", n)
+	}
+}
+
+func TestSnapshotPrometheusSummaryCumulative(t *testing.T) {
+	t.Parallel()
+	r := metrics.NewRegistry()
+
+	h := r.NewHistogramWithOptions("job", nil, "duration", metrics.HistogramOptions{
+		Max:     1000,
+		Window:  100 * time.Millisecond,
+		Buckets: 1,
+	})
+	h.RecordValue(10)
+	h.RecordValue(20)
+	time.Sleep(150 * time.Millisecond)
+	h.RecordValue(30)
+
+	out := r.SnapshotPrometheus()
+	for _, want := range []string{
+		"job_duration{quantile=\"0.5\"} 30\n",
+		"job_duration_sum 60\n",
+		"job_duration_count 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Prometheus output was %v, but expected it to contain %v", out, want)
+		}
+	}
+	if c, _ := r.Snapshot(); c["job duration.count"] != 1 {
+		t.Errorf("Windowed count was %v, but expected 1", c["job duration.count"])
+	}
+}
+
+func TestSnapshotPrometheusConflicts(t *testing.T) {
+	t.Parallel()
+	r := metrics.NewRegistry()
+
+	r.NewCounter("http_response", nil, "200").AddN(5)
+	r.NewGauge("http_response", nil, "500").Set(3)
+	r.NewCounter("jobs", map[string]string{"field": "x"}, "1").Add()
+
+	out := r.SnapshotPrometheus()
+	for _, want := range []string{
+		"# TYPE http_response counter\nhttp_response{field=\"200\"} 5\n",
+		"# TYPE http_response_gauge gauge\nhttp_response_gauge{field=\"500\"} 3\n",
+		"# TYPE jobs__1 counter\njobs__1{field=\"x\"} 1\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Prometheus output was %v, but expected it to contain %v", out, want)
+		}
+	}
+	if n := strings.Count(out, "# TYPE http_response "); n != 1 {
+		t.Errorf("http_response was declared %d times, but expected once", n)
+	}
+}
+
 func BenchmarkCounterAdd(b *testing.B) {
 	metrics.Reset()
 
@@ -289,6 +988,22 @@ func BenchmarkGaugeSet(b *testing.B) {
 	})
 }
 
+func BenchmarkCounterAddFolded(b *testing.B) {
+	r := metrics.NewRegistry()
+	r.SetLimits(metrics.Limits{MaxSeries: 1})
+	r.NewCounter("foo", nil, "value").Add()
+	c := r.NewCounter("foo", map[string]string{"user": "a"}, "value")
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Add()
+		}
+	})
+}
+
 func BenchmarkHistogramRecordValue(b *testing.B) {
 	metrics.Reset()
 	h := metrics.NewHistogram("foo",
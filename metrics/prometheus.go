@@ -0,0 +1,168 @@
+package metrics
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// PrometheusContentType is the content type of the Prometheus text exposition format produced by SnapshotPrometheus.
+const PrometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// promFamily is a group of samples sharing one Prometheus metric name and type.
+type promFamily struct {
+	name  string
+	typ   string
+	lines []promLine
+}
+
+// promLine is a sample of a family. Its metric name is the family name followed by suffix, e.g. _sum of a summary.
+type promLine struct {
+	suffix string
+	rest   string // labels and value.
+}
+
+// promTypeOrder decides which family keeps a metric name declared with several types.
+var promTypeOrder = map[string]int{"counter": 0, "gauge": 1, "summary": 2}
+
+// SnapshotPrometheus provides all collected metrics of the DefaultRegistry in Prometheus text exposition format.
+// See Registry.SnapshotPrometheus.
+func SnapshotPrometheus() string {
+	return DefaultRegistry.SnapshotPrometheus()
+}
+
+// SnapshotPrometheus provides all collected metrics in Prometheus text exposition format.
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+//
+// Counters and gauges are named <measurement>_<field> and histograms are exposed as summaries
+// named <measurement>_<field> with a quantile label for every percentile, _sum and _count. The quantiles cover the
+// window of the histogram while _sum and _count, which Prometheus expects never to decrease, are cumulative.
+// The min, max, mean and stddev of a histogram are exposed as gauges named <measurement>_<field>_<stat>. Meters are
+// exposed as a counter <measurement>_<field>_count and gauges <measurement>_<field>_<rate>. Tags become labels.
+// A field which is not a valid metric name, such as the status code 200, is exposed as a "field"
+// label of the <measurement> metric instead, unless a tag is named "field" already.
+// A metric name is declared with one type only, so when samples of different types end up with the same name,
+// e.g. counters and gauges of numeric fields in one measurement, all but the counters are suffixed by their type.
+func (r *Registry) SnapshotPrometheus() string {
+	var families []*promFamily
+	byKey := make(map[string]*promFamily)
+
+	for _, s := range r.collect() {
+		labels := make(map[string]string, len(s.tags)+1)
+		for k, v := range s.tags {
+			labels[promLabelName(k)] = v
+		}
+
+		name := promName(s.measurement)
+		if s.field != "" {
+			if _, taken := labels["field"]; taken || isPromNameStart(s.field[0]) {
+				name = name + "_" + promName(s.field)
+			} else {
+				labels["field"] = s.field
+			}
+		}
+
+		// suffix is appended to the family name in the sample's metric name for the _sum and _count of a summary.
+		var suffix string
+		typ := "counter"
+		switch {
+		case s.kind == gaugeKind:
+			typ = "gauge"
+		case s.kind == histogramKind && s.quantile > 0:
+			typ = "summary"
+			// round to 12 digits so percentiles such as 99.9 are written as 0.999 rather than 0.9990000000000001.
+			labels["quantile"] = strconv.FormatFloat(s.quantile, 'g', 12, 64)
+		case s.kind == histogramKind && (s.stat == "sum" || s.stat == "count"):
+			typ = "summary"
+			suffix = "_" + s.stat
+		case s.kind == histogramKind, s.kind == meterKind && s.stat != "count":
+			typ = "gauge"
+			name = name + "_" + s.stat
+		case s.kind == meterKind:
+			name = name + "_" + s.stat
+		}
+
+		key := name + " " + typ
+		f, ok := byKey[key]
+		if !ok {
+			f = &promFamily{name: name, typ: typ}
+			byKey[key] = f
+			families = append(families, f)
+		}
+		value := s.value
+		if s.total != nil {
+			value = s.total
+		}
+		f.lines = append(f.lines, promLine{suffix: suffix, rest: promLabels(labels) + " " + formatValue(value)})
+	}
+
+	// types holds the type which keeps each metric name.
+	types := make(map[string]string)
+	for _, f := range families {
+		if t, ok := types[f.name]; !ok || promTypeOrder[f.typ] < promTypeOrder[t] {
+			types[f.name] = f.typ
+		}
+	}
+
+	var buffer bytes.Buffer
+	for _, f := range families {
+		name := f.name
+		if types[name] != f.typ {
+			name = name + "_" + f.typ
+		}
+		buffer.WriteString("# TYPE " + name + " " + f.typ + "\n")
+		for _, l := range f.lines {
+			buffer.WriteString(name + l.suffix + l.rest)
+			buffer.WriteString("\n")
+		}
+	}
+	return buffer.String()
+}
+
+// promName replaces characters which are not allowed in a Prometheus metric name by underscore.
+func promName(s string) string {
+	return sanitizePromName(s, true)
+}
+
+// promLabelName replaces characters which are not allowed in a Prometheus label name by underscore.
+func promLabelName(s string) string {
+	return sanitizePromName(s, false)
+}
+
+// sanitizePromName replaces invalid characters by underscore and prefixes names starting with a digit.
+// Colons are only allowed in metric names.
+func sanitizePromName(s string, colon bool) string {
+	b := []byte(s)
+	for i, c := range b {
+		switch {
+		case isPromNameStart(c) && (colon || c != ':'):
+		case c >= '0' && c <= '9':
+		default:
+			b[i] = '_'
+		}
+	}
+	if len(b) > 0 && s[0] >= '0' && s[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
+
+// isPromNameStart reports whether c can start a Prometheus metric name.
+func isPromNameStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == ':'
+}
+
+// promLabels formats labels sorted by name: {a="1",b="2"}.
+func promLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := sortedKeys(labels)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + `="` + promLabelEscaper.Replace(labels[k]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
@@ -0,0 +1,679 @@
+package metrics
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRegistry holds the metrics created by the package-level functions NewCounter, NewGauge, NewHistogram, NewMeter
+// and NewTimer.
+var DefaultRegistry = NewRegistry()
+
+// Registry owns a set of counters, gauges, histograms, meters and timers.
+// Float counters and gauges are held apart from their integer counterparts. Metrics of different registries never share values,
+// so two applications in one process or tests running in parallel can each use their own registry.
+type Registry struct {
+	mu         sync.RWMutex
+	counters   map[string]*counterEntry
+	gauges     map[string]*gaugeEntry
+	fcounters  map[string]*floatEntry
+	fgauges    map[string]*floatEntry
+	histograms map[string]*histogramEntry
+	meters     map[string]*meterEntry
+	timers     map[string]*timerEntry
+	emitter    Emitter
+
+	limits      Limits
+	series      map[string]map[string]int // number of entries per series key per measurement.
+	seriesTotal int                       // number of distinct series keys.
+	overflows   map[string]*overflow      // decisions for series over the limits keyed by series.
+}
+
+// NewRegistry returns new instance of an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{
+		counters:   make(map[string]*counterEntry),
+		gauges:     make(map[string]*gaugeEntry),
+		fcounters:  make(map[string]*floatEntry),
+		fgauges:    make(map[string]*floatEntry),
+		histograms: make(map[string]*histogramEntry),
+		meters:     make(map[string]*meterEntry),
+		timers:     make(map[string]*timerEntry),
+		series:     make(map[string]map[string]int),
+	}
+}
+
+// seriesInfo holds the components of a metric series.
+type seriesInfo struct {
+	name  string
+	tags  map[string]string
+	field string
+}
+
+func newSeriesInfo(name string, tags map[string]string, field string) seriesInfo {
+	// copy tags so later changes to the caller's map do not alter the series.
+	t := make(map[string]string, len(tags))
+	for k, v := range tags {
+		t[k] = v
+	}
+	return seriesInfo{name: name, tags: t, field: field}
+}
+
+// sample returns a sample of the series with the given value.
+func (i seriesInfo) sample(k kind, stat string, value interface{}) sample {
+	return sample{
+		kind:        k,
+		measurement: i.name,
+		tags:        i.tags,
+		field:       i.field,
+		stat:        stat,
+		value:       value,
+	}
+}
+
+// batchFunc is a lazily-called value function with an optional per-snapshot initializer shared by its key.
+type batchFunc struct {
+	key  interface{}
+	init func()
+}
+
+type counterEntry struct {
+	value uint64 // accessed atomically, first in struct for 64-bit alignment.
+	seriesInfo
+	fn func() uint64
+	batchFunc
+}
+
+type gaugeEntry struct {
+	value int64 // accessed atomically, first in struct for 64-bit alignment.
+	seriesInfo
+	fn func() int64
+	batchFunc
+}
+
+// NewCounter returns new instance of Counter.
+func (r *Registry) NewCounter(name string, tags map[string]string, field string) *Counter {
+	return &Counter{
+		Name:     name,
+		Tags:     tags,
+		Field:    field,
+		registry: r,
+		series:   MakeSeries(name, tags, field),
+	}
+}
+
+// NewGauge returns new instance of Gauge.
+func (r *Registry) NewGauge(name string, tags map[string]string, field string) *Gauge {
+	return &Gauge{
+		Name:     name,
+		Tags:     tags,
+		Field:    field,
+		registry: r,
+		series:   MakeSeries(name, tags, field),
+	}
+}
+
+// NewFloatCounter returns new instance of FloatCounter.
+func (r *Registry) NewFloatCounter(name string, tags map[string]string, field string) *FloatCounter {
+	return &FloatCounter{
+		Name:     name,
+		Tags:     tags,
+		Field:    field,
+		registry: r,
+		series:   MakeSeries(name, tags, field),
+	}
+}
+
+// NewFloatGauge returns new instance of FloatGauge.
+func (r *Registry) NewFloatGauge(name string, tags map[string]string, field string) *FloatGauge {
+	return &FloatGauge{
+		Name:     name,
+		Tags:     tags,
+		Field:    field,
+		registry: r,
+		series:   MakeSeries(name, tags, field),
+	}
+}
+
+// NewMeter returns new instance of Meter.
+func (r *Registry) NewMeter(name string, tags map[string]string, field string) *Meter {
+	return &Meter{
+		Name:     name,
+		Tags:     tags,
+		Field:    field,
+		registry: r,
+		series:   MakeSeries(name, tags, field),
+	}
+}
+
+// NewHistogram returns new instance of Histogram which tracks values between minValue and maxValue.
+// If a histogram of the same series already exists, the new Histogram records into it.
+func (r *Registry) NewHistogram(name string, tags map[string]string, field string, minValue, maxValue int64) *Histogram {
+	return r.NewHistogramWithOptions(name, tags, field, HistogramOptions{Min: minValue, Max: maxValue})
+}
+
+// NewHistogramWithOptions returns new instance of Histogram configured by opts.
+// If a histogram of the same series already exists, the new Histogram records into it and opts are ignored.
+func (r *Registry) NewHistogramWithOptions(name string, tags map[string]string, field string, opts HistogramOptions) *Histogram {
+	h := &Histogram{
+		Name:     name,
+		Tags:     tags,
+		Field:    field,
+		registry: r,
+		series:   MakeSeries(name, tags, field),
+		opts:     opts,
+	}
+	r.histogram(name, tags, field, h.series, opts, false)
+	return h
+}
+
+// NewTimer returns new instance of Timer which records durations in milliseconds up to one hour.
+// If a timer of the same series already exists, the new Timer records into it.
+func (r *Registry) NewTimer(name string, tags map[string]string, field string) *Timer {
+	return r.NewTimerWithOptions(name, tags, field, TimerOptions{})
+}
+
+// NewTimerWithOptions returns new instance of Timer configured by opts.
+// If a timer of the same series already exists, the new Timer records into it and opts are ignored.
+func (r *Registry) NewTimerWithOptions(name string, tags map[string]string, field string, opts TimerOptions) *Timer {
+	if opts.Unit <= 0 {
+		opts.Unit = time.Millisecond
+	}
+	if opts.Histogram.Max <= 0 {
+		opts.Histogram.Max = int64(time.Hour / opts.Unit)
+	}
+	t := &Timer{
+		Name:     name,
+		Tags:     tags,
+		Field:    field,
+		registry: r,
+		series:   MakeSeries(name, tags, field),
+		opts:     opts,
+	}
+	r.timer(name, tags, field, t.series, opts, false)
+	return t
+}
+
+// counter returns the counter entry of series and creates it when it does not exist.
+// It returns nil when the series is over the limits and dropped.
+func (r *Registry) counter(name string, tags map[string]string, field, series string) *counterEntry {
+	r.mu.RLock()
+	s, o := r.lookup(series)
+	e, ok := r.counters[s]
+	r.mu.RUnlock()
+	if ok || o.dropped() {
+		o.count()
+		return e
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok = r.counters[series]; ok {
+		return e
+	}
+	info, series, admitted := r.limit(newSeriesInfo(name, tags, field), series, true, true)
+	if !admitted {
+		return nil
+	}
+	if e, ok = r.counters[series]; !ok {
+		e = &counterEntry{seriesInfo: info}
+		r.counters[series] = e
+		r.track(info)
+	}
+	return e
+}
+
+// floatCounter returns the float counter entry of series and creates it when it does not exist.
+// It returns nil when the series is over the limits and dropped.
+func (r *Registry) floatCounter(name string, tags map[string]string, field, series string) *floatEntry {
+	r.mu.RLock()
+	s, o := r.lookup(series)
+	e, ok := r.fcounters[s]
+	r.mu.RUnlock()
+	if ok || o.dropped() {
+		o.count()
+		return e
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok = r.fcounters[series]; ok {
+		return e
+	}
+	info, series, admitted := r.limit(newSeriesInfo(name, tags, field), series, true, true)
+	if !admitted {
+		return nil
+	}
+	if e, ok = r.fcounters[series]; !ok {
+		e = &floatEntry{seriesInfo: info}
+		r.fcounters[series] = e
+		r.track(info)
+	}
+	return e
+}
+
+// floatGauge returns the float gauge entry of series and creates it when it does not exist.
+// A gauge set by SetFunc is replaced by a gauge holding a value.
+// It returns nil when the series is over the limits and dropped.
+func (r *Registry) floatGauge(name string, tags map[string]string, field, series string) *floatEntry {
+	r.mu.RLock()
+	s, o := r.lookup(series)
+	e, ok := r.fgauges[s]
+	r.mu.RUnlock()
+	if ok && e.fn == nil || o.dropped() {
+		o.count()
+		return e
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok = r.fgauges[series]
+	if !ok {
+		info, s, admitted := r.limit(newSeriesInfo(name, tags, field), series, true, true)
+		if !admitted {
+			return nil
+		}
+		series = s
+		if e, ok = r.fgauges[series]; !ok {
+			e = &floatEntry{seriesInfo: info}
+			r.fgauges[series] = e
+			r.track(info)
+			return e
+		}
+	}
+	if e.fn != nil {
+		e = &floatEntry{seriesInfo: e.seriesInfo}
+		r.fgauges[series] = e
+	}
+	return e
+}
+
+// histogram returns the histogram entry of series and creates it configured by opts when it does not exist.
+// update tells whether a value is recorded into the entry. It returns nil when the series is over the limits and dropped.
+func (r *Registry) histogram(name string, tags map[string]string, field, series string, opts HistogramOptions, update bool) *histogramEntry {
+	r.mu.RLock()
+	s, o := r.lookup(series)
+	e, ok := r.histograms[s]
+	r.mu.RUnlock()
+	if ok || o.dropped() {
+		if update {
+			o.count()
+		}
+		return e
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok = r.histograms[series]; ok {
+		return e
+	}
+	info, series, admitted := r.limit(newSeriesInfo(name, tags, field), series, true, update)
+	if !admitted {
+		return nil
+	}
+	if e, ok = r.histograms[series]; !ok {
+		e = newHistogramEntry(info, opts)
+		r.histograms[series] = e
+		r.track(info)
+	}
+	return e
+}
+
+// timer returns the timer entry of series and creates it configured by opts when it does not exist.
+// update tells whether a duration is recorded into the entry. It returns nil when the series is over the limits and dropped.
+func (r *Registry) timer(name string, tags map[string]string, field, series string, opts TimerOptions, update bool) *timerEntry {
+	r.mu.RLock()
+	s, o := r.lookup(series)
+	e, ok := r.timers[s]
+	r.mu.RUnlock()
+	if ok || o.dropped() {
+		if update {
+			o.count()
+		}
+		return e
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok = r.timers[series]; ok {
+		return e
+	}
+	info, series, admitted := r.limit(newSeriesInfo(name, tags, field), series, true, update)
+	if !admitted {
+		return nil
+	}
+	if e, ok = r.timers[series]; !ok {
+		e = newTimerEntry(info, opts)
+		r.timers[series] = e
+		r.track(info)
+	}
+	return e
+}
+
+// meter returns the meter entry of series and creates it when it does not exist.
+// It returns nil when the series is over the limits and dropped.
+func (r *Registry) meter(name string, tags map[string]string, field, series string) *meterEntry {
+	r.mu.RLock()
+	s, o := r.lookup(series)
+	e, ok := r.meters[s]
+	r.mu.RUnlock()
+	if ok || o.dropped() {
+		o.count()
+		return e
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok = r.meters[series]; ok {
+		return e
+	}
+	info, series, admitted := r.limit(newSeriesInfo(name, tags, field), series, true, true)
+	if !admitted {
+		return nil
+	}
+	if e, ok = r.meters[series]; !ok {
+		e = newMeterEntry(info, time.Now())
+		r.meters[series] = e
+		r.track(info)
+	}
+	return e
+}
+
+// gauge returns the gauge entry of series and creates it when it does not exist.
+// A gauge set by SetFunc is replaced by a gauge holding a value.
+// It returns nil when the series is over the limits and dropped.
+func (r *Registry) gauge(name string, tags map[string]string, field, series string) *gaugeEntry {
+	r.mu.RLock()
+	s, o := r.lookup(series)
+	e, ok := r.gauges[s]
+	r.mu.RUnlock()
+	if ok && e.fn == nil || o.dropped() {
+		o.count()
+		return e
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, ok = r.gauges[series]
+	if !ok {
+		info, s, admitted := r.limit(newSeriesInfo(name, tags, field), series, true, true)
+		if !admitted {
+			return nil
+		}
+		series = s
+		if e, ok = r.gauges[series]; !ok {
+			e = &gaugeEntry{seriesInfo: info}
+			r.gauges[series] = e
+			r.track(info)
+			return e
+		}
+	}
+	if e.fn != nil {
+		e = &gaugeEntry{seriesInfo: e.seriesInfo}
+		r.gauges[series] = e
+	}
+	return e
+}
+
+func (r *Registry) setCounterFunc(name string, tags map[string]string, field, series string, key interface{}, init func(), f func() uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info := newSeriesInfo(name, tags, field)
+	if _, ok := r.counters[series]; !ok {
+		if _, _, admitted := r.limit(info, series, false, true); !admitted {
+			return
+		}
+		r.track(info)
+	}
+	r.counters[series] = &counterEntry{
+		seriesInfo: info,
+		fn:         f,
+		batchFunc:  batchFunc{key: key, init: init},
+	}
+}
+
+func (r *Registry) setGaugeFunc(name string, tags map[string]string, field, series string, key interface{}, init func(), f func() int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info := newSeriesInfo(name, tags, field)
+	if _, ok := r.gauges[series]; !ok {
+		if _, _, admitted := r.limit(info, series, false, true); !admitted {
+			return
+		}
+		r.track(info)
+	}
+	r.gauges[series] = &gaugeEntry{
+		seriesInfo: info,
+		fn:         f,
+		batchFunc:  batchFunc{key: key, init: init},
+	}
+}
+
+func (r *Registry) setFloatCounterFunc(name string, tags map[string]string, field, series string, key interface{}, init func(), f func() float64) {
+	r.setFloatFunc(r.fcounters, name, tags, field, series, key, init, f)
+}
+
+func (r *Registry) setFloatGaugeFunc(name string, tags map[string]string, field, series string, key interface{}, init func(), f func() float64) {
+	r.setFloatFunc(r.fgauges, name, tags, field, series, key, init, f)
+}
+
+func (r *Registry) setFloatFunc(entries map[string]*floatEntry, name string, tags map[string]string, field, series string, key interface{}, init func(), f func() float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	info := newSeriesInfo(name, tags, field)
+	if _, ok := entries[series]; !ok {
+		if _, _, admitted := r.limit(info, series, false, true); !admitted {
+			return
+		}
+		r.track(info)
+	}
+	entries[series] = &floatEntry{
+		seriesInfo: info,
+		fn:         f,
+		batchFunc:  batchFunc{key: key, init: init},
+	}
+}
+
+func (r *Registry) removeCounter(series string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.counters[series]; ok {
+		r.untrack(e.seriesInfo)
+		delete(r.counters, series)
+	}
+}
+
+func (r *Registry) removeGauge(series string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.gauges[series]; ok {
+		r.untrack(e.seriesInfo)
+		delete(r.gauges, series)
+	}
+}
+
+func (r *Registry) removeFloatCounter(series string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.fcounters[series]; ok {
+		r.untrack(e.seriesInfo)
+		delete(r.fcounters, series)
+	}
+}
+
+func (r *Registry) removeFloatGauge(series string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.fgauges[series]; ok {
+		r.untrack(e.seriesInfo)
+		delete(r.fgauges, series)
+	}
+}
+
+func (r *Registry) removeHistogram(series string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.histograms[series]; ok {
+		r.untrack(e.seriesInfo)
+		delete(r.histograms, series)
+	}
+}
+
+func (r *Registry) removeMeter(series string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.meters[series]; ok {
+		r.untrack(e.seriesInfo)
+		delete(r.meters, series)
+	}
+}
+
+func (r *Registry) removeTimer(series string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.timers[series]; ok {
+		r.untrack(e.seriesInfo)
+		delete(r.timers, series)
+	}
+}
+
+// Reset clears all counters, gauges, histograms, meters and timers. Limits are kept.
+func (r *Registry) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.counters = make(map[string]*counterEntry)
+	r.gauges = make(map[string]*gaugeEntry)
+	r.fcounters = make(map[string]*floatEntry)
+	r.fgauges = make(map[string]*floatEntry)
+	r.histograms = make(map[string]*histogramEntry)
+	r.meters = make(map[string]*meterEntry)
+	r.timers = make(map[string]*timerEntry)
+	r.series = make(map[string]map[string]int)
+	r.seriesTotal = 0
+	r.overflows = nil
+}
+
+// Snapshot provides all collected metrics keyed by series. Histogram percentiles and statistics are reported as gauges
+// with the statistic appended to the field, e.g. latency.P99. The count of a histogram is reported as a counter and
+// its mean and stddev are rounded to the nearest integer. Likewise, the count of a meter is reported as a counter
+// and its rates as gauges rounded to the nearest integer. A timer is reported like a histogram and the rates of a meter.
+// Float counters and gauges are rounded to the nearest integer as well.
+func (r *Registry) Snapshot() (c map[string]uint64, g map[string]int64) {
+	c = make(map[string]uint64)
+	g = make(map[string]int64)
+	for _, s := range r.collect() {
+		series := MakeSeries(s.measurement, s.tags, s.fieldKey())
+		switch v := s.value.(type) {
+		case uint64:
+			c[series] = v
+		case int64:
+			g[series] = v
+		case float64:
+			if s.kind == counterKind {
+				c[series] = uint64(math.Floor(v + 0.5))
+			} else {
+				g[series] = int64(math.Floor(v + 0.5))
+			}
+		}
+	}
+	return c, g
+}
+
+// collect takes a snapshot of all metrics.
+// Samples are ordered by measurement, then tags, then field so every snapshot renderer produces stable output.
+// NaN and infinite values, which neither JSON nor the Line protocol can represent, are left out.
+func (r *Registry) collect() []sample {
+	// entries are collected under the lock, value functions are called without it
+	// so they may use the registry themselves.
+	r.mu.RLock()
+	counters := make([]*counterEntry, 0, len(r.counters))
+	for _, e := range r.counters {
+		counters = append(counters, e)
+	}
+	gauges := make([]*gaugeEntry, 0, len(r.gauges))
+	for _, e := range r.gauges {
+		gauges = append(gauges, e)
+	}
+	fcounters := make([]*floatEntry, 0, len(r.fcounters))
+	for _, e := range r.fcounters {
+		fcounters = append(fcounters, e)
+	}
+	fgauges := make([]*floatEntry, 0, len(r.fgauges))
+	for _, e := range r.fgauges {
+		fgauges = append(fgauges, e)
+	}
+	histograms := make([]*histogramEntry, 0, len(r.histograms))
+	for _, e := range r.histograms {
+		histograms = append(histograms, e)
+	}
+	meters := make([]*meterEntry, 0, len(r.meters))
+	for _, e := range r.meters {
+		meters = append(meters, e)
+	}
+	timers := make([]*timerEntry, 0, len(r.timers))
+	for _, e := range r.timers {
+		timers = append(timers, e)
+	}
+	r.mu.RUnlock()
+
+	inits := make(map[interface{}]bool)
+	initBatch := func(b batchFunc) {
+		if b.init != nil && !inits[b.key] {
+			inits[b.key] = true
+			b.init()
+		}
+	}
+
+	samples := make([]sample, 0, len(counters)+len(gauges)+len(fcounters)+len(fgauges)+len(histograms)*len(DefaultQuantiles))
+	for _, e := range counters {
+		v := atomic.LoadUint64(&e.value)
+		if e.fn != nil {
+			initBatch(e.batchFunc)
+			v = e.fn()
+		}
+		samples = append(samples, e.sample(counterKind, "", v))
+	}
+
+	for _, e := range gauges {
+		v := atomic.LoadInt64(&e.value)
+		if e.fn != nil {
+			initBatch(e.batchFunc)
+			v = e.fn()
+		}
+		samples = append(samples, e.sample(gaugeKind, "", v))
+	}
+
+	for _, e := range fcounters {
+		samples = append(samples, e.sample(counterKind, "", e.value(initBatch)))
+	}
+
+	for _, e := range fgauges {
+		samples = append(samples, e.sample(gaugeKind, "", e.value(initBatch)))
+	}
+
+	for _, e := range histograms {
+		samples = append(samples, e.samples()...)
+	}
+
+	for _, e := range meters {
+		samples = append(samples, e.samples()...)
+	}
+
+	for _, e := range timers {
+		samples = append(samples, e.samples()...)
+	}
+
+	finite := samples[:0]
+	for _, s := range samples {
+		if v, ok := s.value.(float64); ok && (math.IsNaN(v) || math.IsInf(v, 0)) {
+			continue
+		}
+		finite = append(finite, s)
+	}
+	samples = finite
+	sort.Stable(byMeasurementTagsField(samples))
+	return samples
+}
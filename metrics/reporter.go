@@ -0,0 +1,79 @@
+package metrics
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// ticker calls report every interval in its own goroutine until it is stopped.
+// It is shared by the push reporters.
+type ticker struct {
+	interval time.Duration
+	report   func()
+
+	startOnce sync.Once
+	stopOnce  sync.Once
+	stop      chan struct{}
+	done      chan struct{}
+}
+
+// newTicker returns new instance of ticker. It does not start until start is called.
+func newTicker(interval time.Duration, report func()) *ticker {
+	return &ticker{
+		interval: interval,
+		report:   report,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+}
+
+// start starts the reporting goroutine. Subsequent calls have no effect.
+func (t *ticker) start() {
+	t.startOnce.Do(func() {
+		go t.run()
+	})
+}
+
+func (t *ticker) run() {
+	defer close(t.done)
+	tick := time.NewTicker(t.interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-tick.C:
+			t.report()
+		case <-t.stop:
+			return
+		}
+	}
+}
+
+// close stops the reporting goroutine and waits until an in-flight report is finished.
+// It is safe to call close on a ticker that was never started.
+func (t *ticker) close() {
+	t.stopOnce.Do(func() {
+		close(t.stop)
+		t.startOnce.Do(func() {
+			close(t.done)
+		})
+	})
+	<-t.done
+}
+
+// wait waits for d and reports whether it elapsed before the ticker was closed.
+func (t *ticker) wait(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-t.stop:
+		return false
+	}
+}
+
+// logError is the default error handler of the push reporters.
+func logError(err error) {
+	log.Println("metrics:", err)
+}
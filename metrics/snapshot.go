@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"strings"
+)
+
+// kind identifies the metric type a sample was collected from.
+type kind int
+
+const (
+	counterKind kind = iota
+	gaugeKind
+	histogramKind
+	meterKind
+)
+
+// sample is a single collected value with its series split into measurement, tags and field.
+type sample struct {
+	kind        kind
+	measurement string
+	tags        map[string]string
+	field       string
+	stat        string  // histogram or meter statistic such as P99, empty for counters and gauges.
+	quantile    float64 // quantile between 0 and 1 of a histogram percentile such as P99, zero otherwise.
+	value       interface{}
+	total       interface{} // cumulative count or sum of a histogram since it was created, nil otherwise.
+}
+
+// fieldKey returns the field name as it appears in the series, e.g. latency.P99 for histograms.
+func (s sample) fieldKey() string {
+	if s.stat == "" {
+		return s.field
+	}
+	return s.field + "." + s.stat
+}
+
+// byMeasurementTagsField sorts samples by measurement, then tags, then field and kind.
+// Use a stable sort to keep histogram statistics in the order they were reported.
+type byMeasurementTagsField []sample
+
+func (b byMeasurementTagsField) Len() int      { return len(b) }
+func (b byMeasurementTagsField) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b byMeasurementTagsField) Less(i, j int) bool {
+	if b[i].measurement != b[j].measurement {
+		return b[i].measurement < b[j].measurement
+	}
+	if c := compareTags(b[i].tags, b[j].tags); c != 0 {
+		return c < 0
+	}
+	if b[i].field != b[j].field {
+		return b[i].field < b[j].field
+	}
+	return b[i].kind < b[j].kind
+}
+
+// compareTags compares two tag sets key by key in sorted key order. A tag set that is a prefix of the other sorts first.
+func compareTags(a, b map[string]string) int {
+	ak, bk := sortedKeys(a), sortedKeys(b)
+	for i := 0; i < len(ak) && i < len(bk); i++ {
+		if c := strings.Compare(ak[i], bk[i]); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a[ak[i]], b[bk[i]]); c != 0 {
+			return c
+		}
+	}
+	return len(ak) - len(bk)
+}
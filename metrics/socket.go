@@ -0,0 +1,182 @@
+package metrics
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SocketConfig configures SocketReporter. Only Addr is required.
+type SocketConfig struct {
+	// Addr of the server, e.g. localhost:2003
+	Addr string
+
+	// Interval between reports. Default is 10 seconds.
+	Interval time.Duration
+
+	// DialTimeout is the maximum time to wait for a connection. Default is 5 seconds.
+	DialTimeout time.Duration
+
+	// WriteTimeout is the maximum time a write may take, so a stalled server does not block reporting
+	// and Stop. The connection is closed and redialed on the next report after a timeout. Default is 5 seconds.
+	WriteTimeout time.Duration
+
+	// MTU is the maximum payload of a UDP packet in bytes. Lines are never split across packets,
+	// a line longer than MTU is sent in a packet of its own. Default is 1400. Ignored for TCP.
+	MTU int
+
+	// BufferSize is the maximum number of lines kept while the server is unreachable.
+	// The oldest lines are dropped when the buffer is full. Default is 10000.
+	BufferSize int
+
+	// ErrorHandler is called when a periodic report fails. Default logs the error.
+	ErrorHandler func(error)
+
+	// Registry to report. Default is the DefaultRegistry.
+	Registry *Registry
+}
+
+// SocketReporter periodically writes a snapshot of all metrics over a TCP or UDP connection.
+// A dropped connection is redialed on the next report and lines which could not be sent are buffered until then.
+type SocketReporter struct {
+	cfg     SocketConfig
+	network string
+	render  func(r *Registry, t time.Time) []string
+
+	mu      sync.Mutex
+	conn    net.Conn
+	pending []string
+
+	ticker *ticker
+}
+
+// NewGraphiteReporter returns new instance of SocketReporter which sends metrics in Graphite plaintext
+// format over TCP. See SnapshotGraphite.
+func NewGraphiteReporter(cfg SocketConfig) *SocketReporter {
+	return newSocketReporter("tcp", cfg, func(r *Registry, t time.Time) []string {
+		return splitLines(r.SnapshotGraphite(t))
+	})
+}
+
+// NewInfluxDBUDPReporter returns new instance of SocketReporter which sends metrics in Line protocol
+// format to the InfluxDB UDP service. See SnapshotLinesWithOptions.
+func NewInfluxDBUDPReporter(cfg SocketConfig) *SocketReporter {
+	return newSocketReporter("udp", cfg, func(r *Registry, t time.Time) []string {
+		return splitLines(r.SnapshotLinesWithOptions(LineOptions{Timestamp: t}))
+	})
+}
+
+func newSocketReporter(network string, cfg SocketConfig, render func(*Registry, time.Time) []string) *SocketReporter {
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = 5 * time.Second
+	}
+	if cfg.WriteTimeout <= 0 {
+		cfg.WriteTimeout = 5 * time.Second
+	}
+	if cfg.MTU <= 0 {
+		cfg.MTU = 1400
+	}
+	if cfg.BufferSize <= 0 {
+		cfg.BufferSize = 10000
+	}
+	if cfg.ErrorHandler == nil {
+		cfg.ErrorHandler = logError
+	}
+	if cfg.Registry == nil {
+		cfg.Registry = DefaultRegistry
+	}
+
+	r := &SocketReporter{
+		cfg:     cfg,
+		network: network,
+		render:  render,
+	}
+	r.ticker = newTicker(cfg.Interval, func() {
+		if err := r.Report(); err != nil {
+			r.cfg.ErrorHandler(err)
+		}
+	})
+	return r
+}
+
+// Start starts reporting in the background every configured interval.
+func (r *SocketReporter) Start() {
+	r.ticker.start()
+}
+
+// Stop stops background reporting, writes a final snapshot and closes the connection.
+func (r *SocketReporter) Stop() error {
+	r.ticker.close()
+	err := r.Report()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn != nil {
+		r.conn.Close()
+		r.conn = nil
+	}
+	return err
+}
+
+// Report sends the current snapshot along with lines buffered from failed reports.
+func (r *SocketReporter) Report() error {
+	lines := r.render(r.cfg.Registry, time.Now())
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.pending = append(r.pending, lines...)
+	if n := len(r.pending) - r.cfg.BufferSize; n > 0 {
+		r.pending = append(r.pending[:0], r.pending[n:]...)
+	}
+
+	if r.conn == nil {
+		conn, err := net.DialTimeout(r.network, r.cfg.Addr, r.cfg.DialTimeout)
+		if err != nil {
+			return err
+		}
+		r.conn = conn
+	}
+
+	for len(r.pending) > 0 {
+		n := len(r.pending)
+		if r.network == "udp" {
+			n = packetLines(r.pending, r.cfg.MTU)
+		}
+		r.conn.SetWriteDeadline(time.Now().Add(r.cfg.WriteTimeout))
+		if _, err := r.conn.Write([]byte(strings.Join(r.pending[:n], "\n") + "\n")); err != nil {
+			r.conn.Close()
+			r.conn = nil
+			return err
+		}
+		r.pending = r.pending[n:]
+	}
+	r.pending = nil
+	return nil
+}
+
+// packetLines returns how many of lines fit into a packet of mtu bytes. It is at least one.
+func packetLines(lines []string, mtu int) int {
+	size := len(lines[0]) + 1
+	n := 1
+	for ; n < len(lines); n++ {
+		size += len(lines[n]) + 1
+		if size > mtu {
+			break
+		}
+	}
+	return n
+}
+
+// splitLines splits snapshot output into lines without the trailing newlines.
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
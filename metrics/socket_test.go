@@ -0,0 +1,130 @@
+package metrics_test
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/supershal/stats/metrics"
+)
+
+func TestGraphiteReporterBuffersUntilReconnect(t *testing.T) {
+	metrics.Reset()
+	metrics.NewCounter("foo", map[string]string{"bar": "baz"}, "value").Add()
+
+	// reserve an address and leave it closed to simulate an outage.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	r := metrics.NewGraphiteReporter(metrics.SocketConfig{Addr: addr, DialTimeout: time.Second})
+	if err := r.Report(); err == nil {
+		t.Fatal("Report succeeded, but expected a connection error")
+	}
+
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("could not listen on %v again: %v", addr, err)
+	}
+	defer l.Close()
+
+	received := make(chan []string)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			close(received)
+			return
+		}
+		defer conn.Close()
+		var lines []string
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		received <- lines
+	}()
+
+	if err := r.Report(); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := <-received
+	if len(lines) != 3 {
+		t.Fatalf("Lines were %v, but expected the buffered, current and final snapshot", lines)
+	}
+	for _, l := range lines {
+		if !strings.HasPrefix(l, "foo.bar.baz.value 1 ") {
+			t.Errorf("Line was %v, but expected foo.bar.baz.value 1 <timestamp>", l)
+		}
+	}
+}
+
+func TestInfluxDBUDPReporterSplitsPackets(t *testing.T) {
+	metrics.Reset()
+	for _, f := range []string{"a", "b", "c", "d", "e"} {
+		metrics.NewCounter("foo", nil, f).Add()
+	}
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// every line is "foo x=1i <19 digits timestamp>" which is 30 bytes with the newline.
+	r := metrics.NewInfluxDBUDPReporter(metrics.SocketConfig{Addr: conn.LocalAddr().String(), MTU: 64})
+	if err := r.Report(); err != nil {
+		t.Fatal(err)
+	}
+
+	var lines []string
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for len(lines) < 5 {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Received %v, but expected 5 lines: %v", lines, err)
+		}
+		if n > 64 {
+			t.Errorf("Packet was %d bytes, but expected at most 64", n)
+		}
+		if p := strings.Count(string(buf[:n]), "\n"); p != 2 && len(lines) < 4 {
+			t.Errorf("Packet had %d lines, but expected 2", p)
+		}
+		lines = append(lines, strings.Split(strings.TrimSuffix(string(buf[:n]), "\n"), "\n")...)
+	}
+	for i, f := range []string{"a", "b", "c", "d", "e"} {
+		if !strings.HasPrefix(lines[i], "foo "+f+"=1i ") {
+			t.Errorf("Line was %v, but expected field %v", lines[i], f)
+		}
+	}
+}
+
+func TestGraphiteReporterWriteTimeout(t *testing.T) {
+	reg := metrics.NewRegistry()
+	reg.NewCounter("foo", nil, "value").Add()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	r := metrics.NewGraphiteReporter(metrics.SocketConfig{
+		Addr:         l.Addr().String(),
+		WriteTimeout: time.Nanosecond,
+		Registry:     reg,
+	})
+	err = r.Report()
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("Report returned %v, but expected a write timeout", err)
+	}
+}
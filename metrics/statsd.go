@@ -0,0 +1,205 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StatsdConfig configures StatsdClient.
+type StatsdConfig struct {
+	// Addr of the statsd agent. Default is 127.0.0.1:8125.
+	Addr string
+
+	// Prefix is prepended to every metric name, e.g. "myapp."
+	Prefix string
+
+	// DogStatsD sends tags as DogStatsD tags: <name>:<value>|<type>|#<tag1>:<value1>
+	// Otherwise tags become part of the dotted metric name like in MakeGraphitePath.
+	DogStatsD bool
+
+	// HistogramType is the statsd type of histogram values: "ms" (default) for timers or "h" for DogStatsD histograms.
+	HistogramType string
+
+	// SampleRate between 0 and 1 of counter and histogram updates to send. Default is 1, which sends every update.
+	// Gauges are always sent.
+	SampleRate float64
+
+	// MaxPacketSize is the maximum size of a UDP packet in bytes. Updates are batched up to this size. Default is 1432.
+	MaxPacketSize int
+
+	// FlushInterval is the maximum time an update is buffered before it is sent. Default is 1 second.
+	FlushInterval time.Duration
+
+	// ErrorHandler is called when sending a packet fails. Default logs the error.
+	ErrorHandler func(error)
+}
+
+// StatsdClient sends metric updates to a statsd or DogStatsD agent over UDP.
+// Install it with SetEmitter to send every update of Counter, Gauge, Histogram, Meter, Timer, FloatCounter and FloatGauge:
+//
+//	c, err := metrics.NewStatsdClient(metrics.StatsdConfig{DogStatsD: true})
+//	metrics.SetEmitter(c)
+//	defer c.Close()
+type StatsdClient struct {
+	cfg  StatsdConfig
+	conn net.Conn
+
+	mu  sync.Mutex
+	buf bytes.Buffer
+
+	ticker *ticker
+}
+
+// NewStatsdClient returns new instance of StatsdClient which flushes buffered updates every FlushInterval.
+func NewStatsdClient(cfg StatsdConfig) (*StatsdClient, error) {
+	if cfg.Addr == "" {
+		cfg.Addr = "127.0.0.1:8125"
+	}
+	if cfg.HistogramType == "" {
+		cfg.HistogramType = "ms"
+	}
+	if cfg.SampleRate <= 0 || cfg.SampleRate > 1 {
+		cfg.SampleRate = 1
+	}
+	if cfg.MaxPacketSize <= 0 {
+		cfg.MaxPacketSize = 1432
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = time.Second
+	}
+	if cfg.ErrorHandler == nil {
+		cfg.ErrorHandler = logError
+	}
+
+	conn, err := net.Dial("udp", cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &StatsdClient{
+		cfg:  cfg,
+		conn: conn,
+	}
+	c.ticker = newTicker(cfg.FlushInterval, func() {
+		if err := c.Flush(); err != nil {
+			c.cfg.ErrorHandler(err)
+		}
+	})
+	c.ticker.start()
+	return c, nil
+}
+
+// Count sends a counter increment.
+func (c *StatsdClient) Count(name string, tags map[string]string, field string, delta uint64) {
+	c.send(name, tags, field, strconv.FormatUint(delta, 10), "c", true)
+}
+
+// Gauge sends a gauge value.
+func (c *StatsdClient) Gauge(name string, tags map[string]string, field string, value int64) {
+	c.send(name, tags, field, strconv.FormatInt(value, 10), "g", false)
+}
+
+// FloatCount sends a counter increment with a fractional value. NaN and infinite values are not sent.
+func (c *StatsdClient) FloatCount(name string, tags map[string]string, field string, delta float64) {
+	if math.IsNaN(delta) || math.IsInf(delta, 0) {
+		return
+	}
+	c.send(name, tags, field, strconv.FormatFloat(delta, 'f', -1, 64), "c", true)
+}
+
+// FloatGauge sends a gauge value with a fractional value. NaN and infinite values are not sent.
+func (c *StatsdClient) FloatGauge(name string, tags map[string]string, field string, value float64) {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return
+	}
+	c.send(name, tags, field, strconv.FormatFloat(value, 'f', -1, 64), "g", false)
+}
+
+// Histogram sends a timer or histogram value depending on HistogramType.
+func (c *StatsdClient) Histogram(name string, tags map[string]string, field string, value int64) {
+	c.send(name, tags, field, strconv.FormatInt(value, 10), c.cfg.HistogramType, true)
+}
+
+// Flush sends all buffered updates.
+func (c *StatsdClient) Flush() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.flush()
+}
+
+// Close flushes buffered updates and closes the connection.
+func (c *StatsdClient) Close() error {
+	c.ticker.close()
+	err := c.Flush()
+	if cerr := c.conn.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// send formats one update as <name>:<value>|<type>[|@<rate>][|#<tags>] and adds it to the batch.
+func (c *StatsdClient) send(name string, tags map[string]string, field, value, typ string, sampled bool) {
+	if sampled && c.cfg.SampleRate < 1 && rand.Float64() >= c.cfg.SampleRate {
+		return
+	}
+
+	var metric string
+	if c.cfg.DogStatsD {
+		metric = c.cfg.Prefix + graphiteNode(name) + "." + graphiteNode(field)
+	} else {
+		metric = c.cfg.Prefix + MakeGraphitePath(name, tags, field)
+	}
+	line := metric + ":" + value + "|" + typ
+	// plain statsd reads a signed gauge value as a change of the gauge, so a negative value is sent after setting it to zero.
+	if typ == "g" && !c.cfg.DogStatsD && strings.HasPrefix(value, "-") {
+		line = metric + ":0|g\n" + line
+	}
+	if sampled && c.cfg.SampleRate < 1 {
+		line += "|@" + strconv.FormatFloat(c.cfg.SampleRate, 'f', -1, 64)
+	}
+	if c.cfg.DogStatsD && len(tags) > 0 {
+		line += "|#" + dogstatsdTags(tags)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.buf.Len() > 0 && c.buf.Len()+1+len(line) > c.cfg.MaxPacketSize {
+		if err := c.flush(); err != nil {
+			c.cfg.ErrorHandler(err)
+		}
+	}
+	if c.buf.Len() > 0 {
+		c.buf.WriteByte('\n')
+	}
+	c.buf.WriteString(line)
+}
+
+// flush sends the batch as one packet. c.mu must be held.
+func (c *StatsdClient) flush() error {
+	if c.buf.Len() == 0 {
+		return nil
+	}
+	_, err := c.conn.Write(c.buf.Bytes())
+	c.buf.Reset()
+	return err
+}
+
+// dogstatsdTags formats tags sorted by key: <tag1>:<value1>,<tag2>:<value2>
+func dogstatsdTags(tags map[string]string) string {
+	pairs := make([]string, 0, len(tags))
+	for k, v := range tags {
+		pairs = append(pairs, dogstatsdReplacer.Replace(k)+":"+dogstatsdReplacer.Replace(v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// dogstatsdReplacer removes characters that delimit tags in the DogStatsD protocol.
+var dogstatsdReplacer = strings.NewReplacer(",", "_", "|", "_", "#", "_", "\n", "_")
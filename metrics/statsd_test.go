@@ -0,0 +1,169 @@
+package metrics_test
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/supershal/stats/metrics"
+)
+
+// readPacket starts a statsd client against a local UDP listener, runs emit and returns the flushed packet.
+func readPacket(t *testing.T, cfg metrics.StatsdConfig, emit func()) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	cfg.Addr = conn.LocalAddr().String()
+	cfg.FlushInterval = time.Hour
+	c, err := metrics.NewStatsdClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	metrics.SetEmitter(c)
+	defer metrics.SetEmitter(nil)
+	emit()
+	if err := c.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 1500)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
+
+func emitAll() {
+	tags := map[string]string{
+		"host": "a",
+		"code": "200",
+	}
+	metrics.NewCounter("http_response", tags, "total").AddN(2)
+	metrics.NewGauge("http_response", tags, "size").Set(512)
+	h := metrics.NewHistogram("http_response", tags, "latency", 0, 1000)
+	h.RecordValue(12)
+	h.Remove()
+}
+
+func TestStatsdClient(t *testing.T) {
+	metrics.Reset()
+
+	packet := readPacket(t, metrics.StatsdConfig{Prefix: "app."}, emitAll)
+	want := strings.Join([]string{
+		"app.http_response.code.200.host.a.total:2|c",
+		"app.http_response.code.200.host.a.size:512|g",
+		"app.http_response.code.200.host.a.latency:12|ms",
+	}, "\n")
+	if packet != want {
+		t.Errorf("Packet was %v, but expected %v", packet, want)
+	}
+}
+
+func TestStatsdClientDogStatsD(t *testing.T) {
+	metrics.Reset()
+
+	packet := readPacket(t, metrics.StatsdConfig{
+		DogStatsD:     true,
+		HistogramType: "h",
+		SampleRate:    0.999999,
+	}, emitAll)
+	want := strings.Join([]string{
+		"http_response.total:2|c|@0.999999|#code:200,host:a",
+		"http_response.size:512|g|#code:200,host:a",
+		"http_response.latency:12|h|@0.999999|#code:200,host:a",
+	}, "\n")
+	if packet != want {
+		t.Errorf("Packet was %v, but expected %v", packet, want)
+	}
+}
+
+func TestStatsdClientFloat(t *testing.T) {
+	metrics.Reset()
+
+	packet := readPacket(t, metrics.StatsdConfig{}, func() {
+		metrics.NewFloatCounter("gc", nil, "seconds").Add(0.25)
+		metrics.NewFloatGauge("cpu", nil, "load").Set(1.5)
+	})
+	if v, want := packet, "gc.seconds:0.25|c\ncpu.load:1.5|g"; v != want {
+		t.Errorf("Packet was %v, but expected %v", v, want)
+	}
+}
+
+func TestStatsdClientNegativeGauge(t *testing.T) {
+	metrics.Reset()
+
+	packet := readPacket(t, metrics.StatsdConfig{}, func() {
+		metrics.NewGauge("queue", nil, "depth").Set(-5)
+		metrics.NewFloatGauge("temp", nil, "celsius").Set(-1.5)
+	})
+	if v, want := packet, "queue.depth:0|g\nqueue.depth:-5|g\ntemp.celsius:0|g\ntemp.celsius:-1.5|g"; v != want {
+		t.Errorf("Packet was %v, but expected %v", v, want)
+	}
+
+	packet = readPacket(t, metrics.StatsdConfig{DogStatsD: true}, func() {
+		metrics.NewGauge("queue", nil, "depth").Set(-5)
+	})
+	if v, want := packet, "queue.depth:-5|g"; v != want {
+		t.Errorf("DogStatsD packet was %v, but expected %v", v, want)
+	}
+}
+
+func TestStatsdClientDroppedGaugeAdd(t *testing.T) {
+	metrics.Reset()
+	metrics.SetLimits(metrics.Limits{MaxSeries: 1, Drop: true})
+	defer metrics.SetLimits(metrics.Limits{})
+
+	packet := readPacket(t, metrics.StatsdConfig{}, func() {
+		metrics.NewGauge("a", nil, "value").Set(1)
+		metrics.NewGauge("b", nil, "value").Add(5)
+		metrics.NewFloatGauge("c", nil, "value").Add(0.5)
+	})
+	if v, want := packet, "a.value:1|g"; v != want {
+		t.Errorf("Packet was %v, but expected %v", v, want)
+	}
+}
+
+func TestStatsdClientLimits(t *testing.T) {
+	metrics.Reset()
+	metrics.SetLimits(metrics.Limits{MaxSeriesPerMeasurement: 1})
+	defer metrics.SetLimits(metrics.Limits{})
+
+	packet := readPacket(t, metrics.StatsdConfig{}, func() {
+		for _, user := range []string{"a", "b", "c"} {
+			metrics.NewCounter("requests", map[string]string{"user": user}, "count").Add()
+			metrics.NewHistogram("latency", map[string]string{"user": user}, "value", 0, 100).RecordValue(1)
+		}
+	})
+	want := strings.Join([]string{
+		"requests.user.a.count:1|c",
+		"latency.user.a.value:1|ms",
+		"requests.user.other.count:1|c",
+		"latency.user.other.value:1|ms",
+		"requests.user.other.count:1|c",
+		"latency.user.other.value:1|ms",
+	}, "\n")
+	if packet != want {
+		t.Errorf("Packet was %v, but expected %v", packet, want)
+	}
+}
+
+func TestStatsdClientBatchesByPacketSize(t *testing.T) {
+	metrics.Reset()
+
+	// the second update does not fit into the first packet which is sent before it is buffered.
+	packet := readPacket(t, metrics.StatsdConfig{MaxPacketSize: 10}, func() {
+		metrics.NewCounter("a", nil, "b").Add()
+		metrics.NewCounter("c", nil, "d").Add()
+	})
+	if v, want := packet, "a.b:1|c"; v != want {
+		t.Errorf("Packet was %v, but expected %v", v, want)
+	}
+}
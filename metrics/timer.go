@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"time"
+)
+
+// A Timer measures the duration of an operation and the rate it is performed at, e.g. the latency and
+// throughput of database queries. It records into a histogram and a meter on one series and reports the
+// histogram percentiles and statistics together with the mean, m1, m5 and m15 rates of the meter:
+// <measurement>,<tag1>=<key1> <field>.P50=<value>i,...,<field>.count=<value>i,...,<field>.m1_rate=<value>,...
+type Timer struct {
+	Name     string
+	Tags     map[string]string
+	Field    string
+	registry *Registry
+	series   string
+	opts     TimerOptions
+}
+
+// TimerOptions configures a timer created by NewTimerWithOptions.
+type TimerOptions struct {
+	// Unit durations are recorded in, e.g. time.Microsecond. Default is time.Millisecond.
+	Unit time.Duration
+
+	// Histogram configures the histogram durations are recorded into, in multiples of Unit.
+	// Default Max is one hour.
+	Histogram HistogramOptions
+}
+
+// NewTimer returns new instance of Timer in the DefaultRegistry which records durations in milliseconds up to one hour.
+func NewTimer(name string, tags map[string]string, field string) *Timer {
+	return DefaultRegistry.NewTimer(name, tags, field)
+}
+
+// NewTimerWithOptions returns new instance of Timer in the DefaultRegistry configured by opts.
+func NewTimerWithOptions(name string, tags map[string]string, field string, opts TimerOptions) *Timer {
+	return DefaultRegistry.NewTimerWithOptions(name, tags, field, opts)
+}
+
+// Time calls f and records its duration.
+func (t *Timer) Time(f func()) {
+	start := time.Now()
+	f()
+	t.UpdateSince(start)
+}
+
+// Start starts timing an operation which is recorded when Stop is called on the returned TimerContext:
+//
+//	defer timer.Start().Stop()
+func (t *Timer) Start() TimerContext {
+	return TimerContext{timer: t, start: time.Now()}
+}
+
+// UpdateSince records the duration elapsed since start.
+func (t *Timer) UpdateSince(start time.Time) {
+	t.Update(time.Since(start))
+}
+
+// Update records duration d.
+func (t *Timer) Update(d time.Duration) {
+	e := t.registry.timer(t.Name, t.Tags, t.Field, t.series, t.opts, true)
+	if e == nil {
+		return
+	}
+	v := int64(d / e.unit)
+	e.hist.recordValue(v)
+	e.meter.mark(time.Now(), 1)
+	if em := t.registry.currentEmitter(); em != nil {
+		em.Histogram(e.name, e.tags, e.field, v)
+	}
+}
+
+// Remove removes the timer.
+func (t *Timer) Remove() {
+	t.registry.removeTimer(t.series)
+}
+
+// TimerContext is an operation being timed by a Timer.
+type TimerContext struct {
+	timer *Timer
+	start time.Time
+}
+
+// Stop records the duration since the operation was started and returns it.
+func (c TimerContext) Stop() time.Duration {
+	d := time.Since(c.start)
+	c.timer.Update(d)
+	return d
+}
+
+// timerEntry holds the histogram and meter of a timer.
+type timerEntry struct {
+	seriesInfo
+	unit  time.Duration
+	hist  *histogramEntry
+	meter *meterEntry
+}
+
+func newTimerEntry(info seriesInfo, opts TimerOptions) *timerEntry {
+	return &timerEntry{
+		seriesInfo: info,
+		unit:       opts.Unit,
+		hist:       newHistogramEntry(info, opts.Histogram),
+		meter:      newMeterEntry(info, time.Now()),
+	}
+}
+
+// samples returns the histogram statistics followed by the meter rates. The count of the meter is left out
+// as the histogram reports the count of the durations already.
+func (e *timerEntry) samples() []sample {
+	samples := e.hist.samples()
+	for _, s := range e.meter.samples() {
+		if s.stat != "count" {
+			samples = append(samples, s)
+		}
+	}
+	return samples
+}
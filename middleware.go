@@ -14,41 +14,185 @@ type HTTPStats struct {
 	GlobalTags      map[string]string
 	LogRequestStat  HTTPRequestStatFunc
 	LogResponseStat HTTPResponseStatFunc
+	Registry        *metrics.Registry // registry the default stats functions collect into.
+	Route           RouteFunc         // adds the "route" tag to the stats of each request when set.
+
+	Latency     metrics.HistogramOptions // range, precision, quantiles and window of the default latency histogram.
+	LatencyUnit time.Duration            // unit the default latency histogram records in.
+
+	ConcurrencyWindow time.Duration // interval after which max_concurrency starts over.
+
+	requests    *requestStats
+	concurrency *concurrencyTracker
+	routeMu     sync.RWMutex
+	routeTags   map[string]map[string]string // tags of each route.
 }
 
-// NewHTTPStats provides new instance of HTTPStats
-func NewHTTPStats(tags map[string]string) *HTTPStats {
-	return &HTTPStats{
-		GlobalTags:      tags,
-		LogRequestStat:  makeHttpRequestStat(),
-		LogResponseStat: makeHttpResponseStat(),
+// NewHTTPStats provides new instance of HTTPStats configured by opts.
+// Stats are collected into metrics.DefaultRegistry unless WithRegistry is given.
+func NewHTTPStats(tags map[string]string, opts ...Option) *HTTPStats {
+	s := &HTTPStats{
+		GlobalTags:  tags,
+		Registry:    metrics.DefaultRegistry,
+		Latency:     metrics.HistogramOptions{Min: 0, Max: 10000},
+		LatencyUnit: time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.LatencyUnit <= 0 {
+		s.LatencyUnit = time.Millisecond
 	}
+	if s.Latency.Max <= 0 {
+		s.Latency.Max = 10000
+	}
+	if s.Latency.Window <= 0 {
+		s.Latency.Window = time.Minute
+	}
+	if s.ConcurrencyWindow <= 0 {
+		s.ConcurrencyWindow = time.Minute
+	}
+	s.concurrency = newConcurrencyTracker(s.Registry, s.ConcurrencyWindow)
+	s.requests = newRequestStats(s.Registry, s.Latency.Window)
+	s.LogRequestStat = s.requests.stat
+	s.LogResponseStat = makeHttpResponseStat(s.Registry, s.Latency, s.LatencyUnit)
+	return s
 }
 
 // HTTPRequestStatFunc function type to collect HTTP request metrics.
 // An application can implement this function to provide custom implementation of request metrics collection.
-type HTTPRequestStatFunc func(r http.Request, tags map[string]string)
+// It is called synchronously after the handler returned, so it should record its stats without blocking.
+// tags is shared by all requests of the same route and must not be modified.
+type HTTPRequestStatFunc func(r *http.Request, tags map[string]string)
+
+// requestStats records the default request stats of each tag set.
+type requestStats struct {
+	sets *tagSets
+}
 
-// makeHttpRequestStat implements a func that returns HTTPRequestStatFunc. it counts number of requests by Method across all URI Paths.
+// newRequestStats returns the default request stats collected into reg. body_size is reported over window like the latency.
+func newRequestStats(reg *metrics.Registry, window time.Duration) *requestStats {
+	sizeOpts := bodySizeHistogram
+	sizeOpts.Window = window
+	return &requestStats{
+		sets: newTagSets(func(tags map[string]string) interface{} {
+			return &requestMetrics{
+				reg:        reg,
+				tags:       tags,
+				sizeOpts:   sizeOpts,
+				throughput: reg.NewMeter("http_request", tags, "throughput"),
+				bodyBytes:  reg.NewCounter("http_request", tags, "body_bytes"),
+				upload:     reg.NewMeter("http_request", tags, "upload"),
+				methods:    make(map[string]*metrics.Counter),
+			}
+		}),
+	}
+}
+
+// get returns the metrics of tags.
+func (s *requestStats) get(tags map[string]string) *requestMetrics {
+	return s.sets.get(tags).(*requestMetrics)
+}
+
+// stat is the default HTTPRequestStatFunc. it counts number of requests by Method across all URI Paths
+// and meters the request throughput.
 // If app needs additional tags or per URI stats, the app can implement its own HTTPRequestStatFunc function.
-func makeHttpRequestStat() HTTPRequestStatFunc {
-	return func(r http.Request, tags map[string]string) {
-		field := r.Method
-		metrics.NewCounter("http_request", tags, field).Add()
+func (s *requestStats) stat(r *http.Request, tags map[string]string) {
+	m := s.get(tags)
+	m.method(r.Method).Add()
+	m.throughput.Mark()
+}
+
+// bodySizeHistogram configures the histogram of request body sizes in bytes up to 1GiB. Two significant figures
+// keep the histogram of each tag set small while sizes are still reported within 1%.
+var bodySizeHistogram = metrics.HistogramOptions{Min: 0, Max: 1 << 30, SigFigs: 2}
+
+// requestMetrics holds the metrics of the default request stats of one tag set.
+type requestMetrics struct {
+	reg        *metrics.Registry
+	tags       map[string]string
+	sizeOpts   metrics.HistogramOptions
+	throughput *metrics.Meter
+	bodyBytes  *metrics.Counter
+	upload     *metrics.Meter
+
+	mu      sync.RWMutex
+	methods map[string]*metrics.Counter
+	size    *metrics.Histogram // created by the first request with a body.
+}
+
+// method returns the counter of requests with method.
+func (m *requestMetrics) method(method string) *metrics.Counter {
+	m.mu.RLock()
+	c, ok := m.methods[method]
+	m.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok = m.methods[method]; !ok {
+		c = m.reg.NewCounter("http_request", m.tags, method)
+		m.methods[method] = c
+	}
+	return c
+}
+
+// body records n bytes read from a request body in the body_size histogram, the body_bytes counter
+// and the upload meter, whose rates are in bytes per second. Requests whose body was not read are left out.
+func (m *requestMetrics) body(n int64) {
+	if n == 0 {
+		return
+	}
+	m.bodySize().RecordValue(n)
+	m.bodyBytes.AddN(uint64(n))
+	m.upload.MarkN(uint64(n))
+}
+
+// bodySize returns the body_size histogram. It is created on first use so tag sets whose requests
+// have no body report no body_size.
+func (m *requestMetrics) bodySize() *metrics.Histogram {
+	m.mu.RLock()
+	h := m.size
+	m.mu.RUnlock()
+	if h != nil {
+		return h
 	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.size == nil {
+		m.size = m.reg.NewHistogramWithOptions("http_request", m.tags, "body_size", m.sizeOpts)
+	}
+	return m.size
 }
 
 // HTTPResponseStatFunc function type to collect HTTP response metrics.
 // An application can implement this function to provide custom implementation of response metrics collection.
+// It is called synchronously after the handler returned, so it should record its stats without blocking.
+// tags is shared by all requests of the same route and must not be modified.
 type HTTPResponseStatFunc func(w http.ResponseWriter, tags map[string]string)
 
 // makeHttpResponseStat implements a func that returns HTTPResponseStatFunc. It collects response count by rsponse code, response size and latency.
+// If w is an HTTPResponseTimingCollector, the time to headers, time to first byte and handler duration are collected as well
+// in the fields time_to_headers, time_to_first_byte and duration. Time to first byte is not collected for an empty body.
+// Latency and timings are recorded in multiples of unit into histograms configured by latencyOpts.
+// Hijacked connections are only counted in the "hijacked" field as their response is not written through the StatsWriter.
 // If app needs additional tags or per response stats, the app can implement its own HTTPResponseStatFunc function.
-func makeHttpResponseStat() HTTPResponseStatFunc {
-	// TODO: make min/max latency configurable
-	var latencies = make(map[string]*metrics.Histogram)
-	var lm sync.Mutex
-	// := metrics.NewHistogram("http_response", globalTags, "latency", 0, 10000)
+func makeHttpResponseStat(reg *metrics.Registry, latencyOpts metrics.HistogramOptions, unit time.Duration) HTTPResponseStatFunc {
+	sets := newTagSets(func(tags map[string]string) interface{} {
+		return &responseMetrics{
+			reg:        reg,
+			tags:       tags,
+			opts:       latencyOpts,
+			total:      reg.NewCounter("http_response", tags, "total"),
+			hijacked:   reg.NewCounter("http_response", tags, "hijacked"),
+			size:       reg.NewGauge("http_response", tags, "size"),
+			statuses:   make(map[int]*metrics.Counter),
+			histograms: make(map[string]*metrics.Histogram),
+		}
+	})
 
 	return func(w http.ResponseWriter, tags map[string]string) {
 		var rsc HTTPResponseStatCollector
@@ -56,51 +200,190 @@ func makeHttpResponseStat() HTTPResponseStatFunc {
 		if rsc, ok = w.(HTTPResponseStatCollector); !ok {
 			return
 		}
+		m := sets.get(tags).(*responseMetrics)
+
+		// count hijacked connections, e.g. WebSocket upgrades, apart from responses written through the writer.
+		if h, ok := w.(hijackedCollector); ok && h.Hijacked() {
+			m.hijacked.Add()
+			return
+		}
 
 		// collect status code counts
-		metrics.NewCounter("http_response", tags, strconv.Itoa(rsc.Status())).Add()
-		metrics.NewCounter("http_response", tags, "total").Add()
+		m.status(rsc.Status()).Add()
+		m.total.Add()
 
 		// collect response size guauge
-		metrics.NewGauge("http_response", tags, "size").Set(int64(rsc.Size()))
+		m.size.Set(int64(rsc.Size()))
 
-		// collect response latency histogram
-		lat := rsc.Latency().Nanoseconds() / 1000000
-		series := metrics.MakeSeries("http_response", tags, "latency")
-
-		lm.Lock()
-		if _, ok := latencies[series]; !ok {
-			latencies[series] = metrics.NewHistogram("http_response", tags, "latency", 0, 10000)
+		// collect response latency histograms
+		m.histogram("latency").RecordValue(int64(rsc.Latency() / unit))
+		if tc, ok := w.(HTTPResponseTimingCollector); ok {
+			m.histogram("time_to_headers").RecordValue(int64(tc.TimeToHeaders() / unit))
+			if rsc.Size() > 0 {
+				m.histogram("time_to_first_byte").RecordValue(int64(tc.TimeToFirstByte() / unit))
+			}
+			m.histogram("duration").RecordValue(int64(tc.Duration() / unit))
 		}
-		lm.Unlock()
+	}
+}
+
+// responseMetrics holds the metrics of the default response stats of one tag set.
+type responseMetrics struct {
+	reg      *metrics.Registry
+	tags     map[string]string
+	opts     metrics.HistogramOptions
+	total    *metrics.Counter
+	hijacked *metrics.Counter
+	size     *metrics.Gauge
+
+	mu         sync.RWMutex
+	statuses   map[int]*metrics.Counter
+	histograms map[string]*metrics.Histogram // created by their first record.
+}
+
+// status returns the counter of responses with status code.
+func (m *responseMetrics) status(code int) *metrics.Counter {
+	m.mu.RLock()
+	c, ok := m.statuses[code]
+	m.mu.RUnlock()
+	if ok {
+		return c
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if c, ok = m.statuses[code]; !ok {
+		c = m.reg.NewCounter("http_response", m.tags, strconv.Itoa(code))
+		m.statuses[code] = c
+	}
+	return c
+}
 
-		latency := latencies[series]
-		latency.RecordValue(lat)
+// histogram returns the histogram of field. It is created on first use so tag sets which never record
+// a timing, e.g. because all their connections were hijacked, do not report it.
+func (m *responseMetrics) histogram(field string) *metrics.Histogram {
+	m.mu.RLock()
+	h, ok := m.histograms[field]
+	m.mu.RUnlock()
+	if ok {
+		return h
 	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if h, ok = m.histograms[field]; !ok {
+		h = m.reg.NewHistogramWithOptions("http_response", m.tags, field, m.opts)
+		m.histograms[field] = h
+	}
+	return h
+}
+
+// hijackedCollector is implemented by an HTTPResponseStatCollector which knows whether the connection was hijacked.
+type hijackedCollector interface {
+	Hijacked() bool
 }
 
 // HTTPStatsHandler is a default provided HTTP middleware function to collect global http request and response stats.
+// The number of requests in flight and the highest number of the current ConcurrencyWindow are reported as the
+// http_request gauges in_flight and max_concurrency.
 func (s *HTTPStats) HTTPStatsHandler(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer s.begin(r)()
+		body := countBody(r)
 		// custom response writer
 		t := time.Now()
 		rlw := &StatsWriter{Writer: w, StartTime: t}
-		next.ServeHTTP(rlw, r)
-		go s.LogRequestStat(*r, s.GlobalTags)
-		go s.LogResponseStat(rlw, s.GlobalTags)
+		next.ServeHTTP(rlw.ResponseWriter(), r)
+		rlw.finish()
+		s.log(r, rlw, body)
 	})
 }
 
 // ServeHTTP is Negroni compatible interface for httpStats middleware
 func (s *HTTPStats) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	defer s.begin(r)()
+	body := countBody(r)
 	rlw := &StatsWriter{Writer: w, StartTime: time.Now()}
-	next(rlw, r)
-	go s.LogRequestStat(*r, s.GlobalTags)
-	go s.LogResponseStat(rlw, s.GlobalTags)
+	next(rlw.ResponseWriter(), r)
+	rlw.finish()
+	s.log(r, rlw, body)
+}
+
+// log records the stats of request r and its response w.
+// Stats are recorded synchronously: metric updates are atomic or short critical sections, which is cheaper than
+// starting goroutines for every request, and the stats are up to date as soon as the handler returned.
+// The response stats are taken from a record of w at the time the handler returned, so writes of a hijacked
+// connection or a goroutine the handler left behind neither race with nor change them.
+// The bytes read from body, the counting reader of the request body, are recorded with the default request stats
+// without touching r, which a goroutine the handler left behind may still read.
+func (s *HTTPStats) log(r *http.Request, w *StatsWriter, body *countingBody) {
+	tags := s.tags(r)
+	s.LogRequestStat(r, tags)
+	if body != nil && s.requests != nil {
+		s.requests.get(tags).body(body.BytesRead())
+	}
+	s.LogResponseStat(w.Record(), tags)
 }
 
-// HTTPMetricsSnapshot returns all colleted metrics in metrics Line protocol format.
+// begin counts request r as in flight and returns the func which ends it.
+func (s *HTTPStats) begin(r *http.Request) func() {
+	if s.concurrency == nil {
+		return func() {}
+	}
+	c := s.concurrency.get(s.tags(r))
+	c.inc()
+	return c.dec
+}
+
+// tags returns GlobalTags with the "route" tag of request r added when Route is set.
+// Requests which did not match a route are tagged "unmatched". The tags of each route are built once.
+func (s *HTTPStats) tags(r *http.Request) map[string]string {
+	if s.Route == nil {
+		return s.GlobalTags
+	}
+	route := s.Route(r)
+	if route == "" {
+		route = "unmatched"
+	}
+
+	s.routeMu.RLock()
+	tags, ok := s.routeTags[route]
+	s.routeMu.RUnlock()
+	if ok {
+		return tags
+	}
+
+	s.routeMu.Lock()
+	defer s.routeMu.Unlock()
+	if tags, ok = s.routeTags[route]; ok {
+		return tags
+	}
+	tags = make(map[string]string, len(s.GlobalTags)+1)
+	for k, v := range s.GlobalTags {
+		tags[k] = v
+	}
+	tags["route"] = route
+	if s.routeTags == nil {
+		s.routeTags = make(map[string]map[string]string)
+	}
+	s.routeTags[route] = tags
+	return tags
+}
+
+// HTTPMetricsSnapshot returns all colleted metrics of metrics.DefaultRegistry in metrics Line protocol format.
 // https://github.com/influxdata/influxdb/blob/master/tsdb/README.md
 func HTTPMetricsSnapshotLines() string {
 	return metrics.SnapshotLines()
 }
+
+// HTTPMetricsSnapshotGraphite returns all collected metrics in Graphite plaintext format stamped with time t.
+// http://graphite.readthedocs.io/en/latest/feeding-carbon.html
+func HTTPMetricsSnapshotGraphite(t time.Time) string {
+	return metrics.SnapshotGraphite(t)
+}
+
+// HTTPMetricsSnapshotPrometheus returns all collected metrics in Prometheus text exposition format.
+// https://prometheus.io/docs/instrumenting/exposition_formats/
+func HTTPMetricsSnapshotPrometheus() string {
+	return metrics.SnapshotPrometheus()
+}
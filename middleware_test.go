@@ -1,12 +1,16 @@
 package stats
 
 import (
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	gmux "github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 	"github.com/supershal/stats/metrics"
 )
@@ -22,16 +26,17 @@ func TestHTTPResponseStat(t *testing.T) {
 	}
 	content := []byte("baz")
 	w.Write(content)
-	f := makeHttpResponseStat()
+	f := makeHttpResponseStat(metrics.DefaultRegistry, metrics.HistogramOptions{Min: 0, Max: 10000}, time.Millisecond)
 	f(w, tags)
 
 	c, g := metrics.Snapshot()
 
-	assert.Equal(t, 2, len(c)) // "200" and "total"
-	assert.Equal(t, 7, len(g)) // "size" and 6 "latencies"
+	assert.Equal(t, 6, len(c))  // "200", "total" and count of 4 timings
+	assert.Equal(t, 45, len(g)) // "size" and 6 percentiles plus sum, min, max, mean and stddev of 4 timings
 
 	assert.Equal(t, uint64(1), c["http_response,foo=bar 200"])
 	assert.Equal(t, uint64(1), c["http_response,foo=bar total"])
+	assert.Equal(t, uint64(1), c["http_response,foo=bar latency.count"])
 
 	assert.Equal(t, int64(len(content)), g["http_response,foo=bar size"])
 
@@ -41,23 +46,28 @@ func TestHTTPResponseStat(t *testing.T) {
 	assert.Contains(t, g, "http_response,foo=bar latency.P95")
 	assert.Contains(t, g, "http_response,foo=bar latency.P99")
 	assert.Contains(t, g, "http_response,foo=bar latency.P999")
+	assert.Contains(t, g, "http_response,foo=bar time_to_headers.P99")
+	assert.Contains(t, g, "http_response,foo=bar time_to_first_byte.P99")
+	assert.Contains(t, g, "http_response,foo=bar duration.P99")
 
 }
 
 func TestHTTPRequestStat(t *testing.T) {
 	metrics.Reset()
-	r := http.Request{
+	r := &http.Request{
 		Method: "GET",
 	}
 	tags := map[string]string{
 		"foo": "bar",
 	}
-	f := makeHttpRequestStat()
+	f := newRequestStats(metrics.DefaultRegistry, time.Minute).stat
 	f(r, tags)
-	c, _ := metrics.Snapshot()
+	c, g := metrics.Snapshot()
 
-	assert.Equal(t, 1, len(c))
+	assert.Equal(t, 2, len(c)) // "GET" and throughput count
 	assert.Equal(t, uint64(1), c["http_request,foo=bar GET"])
+	assert.Equal(t, uint64(1), c["http_request,foo=bar throughput.count"])
+	assert.Contains(t, g, "http_request,foo=bar throughput.m1_rate")
 }
 
 func TestHTTPMetricsSnapshotLines(t *testing.T) {
@@ -71,13 +81,13 @@ func TestHTTPMetricsSnapshotLines(t *testing.T) {
 	}
 	content := []byte("baz")
 	w.Write(content)
-	f := makeHttpResponseStat()
+	f := makeHttpResponseStat(metrics.DefaultRegistry, metrics.HistogramOptions{Min: 0, Max: 10000}, time.Millisecond)
 	f(w, tags)
 
 	lines := HTTPMetricsSnapshotLines()
 
-	// "200" + "total" + "size" + 6 "latencies"
-	assert.Equal(t, 9, len(strings.Split(strings.Trim(lines, "\n"), "\n")))
+	// "200" + "total" + "size" + 6 percentiles and count, sum, min, max, mean and stddev of 4 timings
+	assert.Equal(t, 51, len(strings.Split(strings.Trim(lines, "\n"), "\n")))
 
 	assert.Contains(t, lines, "http_response,foo=bar 200=1")
 	assert.Contains(t, lines, "http_response,foo=bar total=1")
@@ -89,5 +99,363 @@ func TestHTTPMetricsSnapshotLines(t *testing.T) {
 	assert.Contains(t, lines, "http_response,foo=bar latency.P95=")
 	assert.Contains(t, lines, "http_response,foo=bar latency.P99=")
 	assert.Contains(t, lines, "http_response,foo=bar latency.P999=")
+	assert.Contains(t, lines, "http_response,foo=bar latency.count=1i")
+	assert.Contains(t, lines, "http_response,foo=bar latency.mean=")
+
+}
+
+func TestHTTPStatsWithRegistry(t *testing.T) {
+	t.Parallel()
+	public := NewHTTPStats(map[string]string{"api": "public"}, WithRegistry(metrics.NewRegistry()))
+	admin := NewHTTPStats(map[string]string{"api": "admin"}, WithRegistry(metrics.NewRegistry()))
+
+	public.LogRequestStat(&http.Request{Method: "GET"}, public.GlobalTags)
+	public.LogRequestStat(&http.Request{Method: "GET"}, public.GlobalTags)
+	admin.LogRequestStat(&http.Request{Method: "POST"}, admin.GlobalTags)
+
+	c, _ := public.Registry.Snapshot()
+	assert.Equal(t, map[string]uint64{"http_request,api=public GET": 2, "http_request,api=public throughput.count": 2}, c)
+
+	c, _ = admin.Registry.Snapshot()
+	assert.Equal(t, map[string]uint64{"http_request,api=admin POST": 1, "http_request,api=admin throughput.count": 1}, c)
+}
+
+func TestHTTPStatsAfterReset(t *testing.T) {
+	t.Parallel()
+	s := NewHTTPStats(map[string]string{"foo": "bar"}, WithRegistry(metrics.NewRegistry()))
+	h := s.HTTPStatsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	s.Registry.Reset()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	c, _ := s.Registry.Snapshot()
+	assert.Equal(t, uint64(1), c["http_response,foo=bar total"])
+	assert.Equal(t, uint64(1), c["http_response,foo=bar latency.count"])
+}
+
+// waitFor polls cond until it is true or fails the test after one second.
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition was not met within 1s")
+}
+
+func TestHTTPStatsRouteTag(t *testing.T) {
+	t.Parallel()
+	s := NewHTTPStats(map[string]string{"foo": "bar"}, WithRegistry(metrics.NewRegistry()), WithRouteTag(MuxRoute))
+
+	g := gmux.NewRouter()
+	g.Handle("/users/{id}", s.HTTPStatsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("user"))
+	}))).Methods("GET")
+
+	for _, path := range []string{"/users/1", "/users/2", "/users/3?q=x"} {
+		g.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	s.HTTPStatsHandler(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/nope", nil))
+
+	c, g2 := s.Registry.Snapshot()
+	assert.Equal(t, uint64(3), c["http_request,foo=bar,route=/users/{id} GET"])
+	assert.Equal(t, uint64(3), c["http_response,foo=bar,route=/users/{id} 200"])
+	assert.Equal(t, uint64(1), c["http_response,foo=bar,route=unmatched 404"])
+	assert.Contains(t, g2, "http_response,foo=bar,route=/users/{id} latency.P99")
+	assert.Equal(t, 16, len(c)) // "GET", "total", status code, throughput and 4 timing counts for the route and for unmatched requests.
+}
+
+func TestHTTPStatsLatencyWindow(t *testing.T) {
+	s := NewHTTPStats(nil, WithRegistry(metrics.NewRegistry()))
+	assert.Equal(t, time.Minute, s.Latency.Window)
+
+	s = NewHTTPStats(nil, WithRegistry(metrics.NewRegistry()), WithLatencyHistogram(metrics.HistogramOptions{Max: 100, Window: 5 * time.Minute}))
+	assert.Equal(t, 5*time.Minute, s.Latency.Window)
+}
+
+func TestHTTPStatsLatencyQuantilesOnly(t *testing.T) {
+	reg := metrics.NewRegistry()
+	s := NewHTTPStats(map[string]string{"foo": "bar"}, WithRegistry(reg),
+		WithLatencyHistogram(metrics.HistogramOptions{Quantiles: []float64{50, 99}}))
+	assert.Equal(t, int64(10000), s.Latency.Max)
+
+	for _, latency := range []time.Duration{5000 * time.Millisecond, 9000 * time.Millisecond} {
+		w := &StatsWriter{Writer: httptest.NewRecorder(), StartTime: time.Now().Add(-latency)}
+		w.Write([]byte("ok"))
+		s.LogResponseStat(w, s.GlobalTags)
+	}
+
+	c, g := reg.Snapshot()
+	assert.Equal(t, uint64(2), c["http_response,foo=bar latency.count"])
+	assert.InDelta(t, 9000, g["http_response,foo=bar latency.max"], 100)
+}
+
+func TestHTTPStatsLatencyOptions(t *testing.T) {
+	reg := metrics.NewRegistry()
+	s := NewHTTPStats(map[string]string{"foo": "bar"}, WithRegistry(reg), WithLatencyUnit(time.Microsecond),
+		WithLatencyHistogram(metrics.HistogramOptions{Max: 60000000, Quantiles: []float64{50, 99}}))
+
+	w := &StatsWriter{Writer: httptest.NewRecorder(), StartTime: time.Now().Add(-1500 * time.Microsecond)}
+	w.Write([]byte("ok"))
+	s.LogResponseStat(w, s.GlobalTags)
+
+	_, g := reg.Snapshot()
+	assert.InDelta(t, 1500, g["http_response,foo=bar latency.P50"], 1000)
+	assert.Contains(t, g, "http_response,foo=bar latency.P99")
+	assert.NotContains(t, g, "http_response,foo=bar latency.P75")
+}
+
+func TestHTTPStatsInFlight(t *testing.T) {
+	t.Parallel()
+	s := NewHTTPStats(map[string]string{"foo": "bar"}, WithRegistry(metrics.NewRegistry()), WithConcurrencyWindow(time.Hour))
+
+	release := make(chan struct{})
+	h := s.HTTPStatsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	done := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		go func() {
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+			done <- struct{}{}
+		}()
+	}
+
+	waitFor(t, func() bool {
+		_, g := s.Registry.Snapshot()
+		return g["http_request,foo=bar in_flight"] == 3
+	})
+	_, g := s.Registry.Snapshot()
+	assert.Equal(t, int64(3), g["http_request,foo=bar max_concurrency"])
+
+	close(release)
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+
+	// the high-water mark is kept until the window ends, however often it is read.
+	_, g = s.Registry.Snapshot()
+	assert.Equal(t, int64(0), g["http_request,foo=bar in_flight"])
+	assert.Equal(t, int64(3), g["http_request,foo=bar max_concurrency"])
+	assert.Contains(t, s.Registry.SnapshotPrometheus(), "http_request_max_concurrency{foo=\"bar\"} 3\n")
+	_, g = s.Registry.Snapshot()
+	assert.Equal(t, int64(3), g["http_request,foo=bar max_concurrency"])
+
+	// Negroni middleware
+	s.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil), func(w http.ResponseWriter, r *http.Request) {
+		_, g := s.Registry.Snapshot()
+		assert.Equal(t, int64(1), g["http_request,foo=bar in_flight"])
+	})
+	_, g = s.Registry.Snapshot()
+	assert.Equal(t, int64(0), g["http_request,foo=bar in_flight"])
+}
+
+func TestHTTPStatsConcurrencyWindow(t *testing.T) {
+	t.Parallel()
+	s := NewHTTPStats(map[string]string{"foo": "bar"}, WithRegistry(metrics.NewRegistry()),
+		WithConcurrencyWindow(10*time.Millisecond))
+	h := s.HTTPStatsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	// the peak starts over when the window ends.
+	waitFor(t, func() bool {
+		_, g := s.Registry.Snapshot()
+		return g["http_request,foo=bar max_concurrency"] == 0
+	})
+
+	// the gauges are recorded again after a reset.
+	s.Registry.Reset()
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	_, g := s.Registry.Snapshot()
+	assert.Equal(t, int64(0), g["http_request,foo=bar in_flight"])
+	assert.Contains(t, g, "http_request,foo=bar max_concurrency")
+}
+
+// discardWriter is a ResponseWriter which discards the response, so benchmarks measure the middleware only.
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+
+func benchmarkHandler(b *testing.B, h http.Handler) {
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		r := httptest.NewRequest("GET", "/users/1", nil)
+		w := &discardWriter{header: make(http.Header)}
+		for pb.Next() {
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+var benchmarkApp = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok"))
+})
+
+func BenchmarkHandlerWithoutStats(b *testing.B) {
+	benchmarkHandler(b, benchmarkApp)
+}
+
+func BenchmarkHTTPStatsHandler(b *testing.B) {
+	s := NewHTTPStats(map[string]string{"host": "a"}, WithRegistry(metrics.NewRegistry()))
+	benchmarkHandler(b, s.HTTPStatsHandler(benchmarkApp))
+}
+
+func BenchmarkHTTPStatsHandlerRouteTag(b *testing.B) {
+	s := NewHTTPStats(map[string]string{"host": "a"}, WithRegistry(metrics.NewRegistry()),
+		WithRouteTag(func(r *http.Request) string { return "/users/{id}" }))
+	benchmarkHandler(b, s.HTTPStatsHandler(benchmarkApp))
+}
+
+// TestHTTPStatsConcurrentLoad serves requests from many goroutines, some of which leave a goroutine behind that
+// keeps writing to the response after the handler returned. Run with -race.
+func TestHTTPStatsConcurrentLoad(t *testing.T) {
+	t.Parallel()
+	s := NewHTTPStats(map[string]string{"foo": "bar"}, WithRegistry(metrics.NewRegistry()),
+		WithRouteTag(func(r *http.Request) string { return r.URL.Path }))
+
+	var late sync.WaitGroup
+	h := s.HTTPStatsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/late":
+			w.Write([]byte("early"))
+			late.Add(1)
+			go func() {
+				defer late.Done()
+				w.Write([]byte("late"))
+			}()
+		case "/error":
+			w.WriteHeader(http.StatusInternalServerError)
+		default:
+			w.Write([]byte("ok"))
+		}
+	}))
+
+	const workers, requests = 8, 100
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < requests; j++ {
+				path := []string{"/ok", "/late", "/error"}[j%3]
+				h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+			}
+		}()
+		go func() {
+			for j := 0; j < 10; j++ {
+				s.Registry.SnapshotLines()
+			}
+		}()
+	}
+	wg.Wait()
+	late.Wait()
+
+	c, g := s.Registry.Snapshot()
+	var total uint64
+	for _, route := range []string{"/ok", "/late", "/error"} {
+		total += c["http_request,foo=bar,route="+route+" GET"]
+	}
+	assert.Equal(t, uint64(workers*requests), total)
+	assert.Equal(t, c["http_response,foo=bar,route=/error total"], c["http_response,foo=bar,route=/error 500"])
+	// the late writes happened after the stats were recorded.
+	assert.Equal(t, int64(len("early")), g["http_response,foo=bar,route=/late size"])
+}
+
+func TestHTTPStatsTimings(t *testing.T) {
+	t.Parallel()
+	s := NewHTTPStats(nil, WithRegistry(metrics.NewRegistry()), WithLatencyUnit(time.Microsecond),
+		WithLatencyHistogram(metrics.HistogramOptions{Max: 10000000}), WithRouteTag(func(r *http.Request) string { return r.URL.Path }))
+
+	h := s.HTTPStatsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(10 * time.Millisecond)
+		if r.URL.Path == "/redirect" {
+			w.WriteHeader(http.StatusFound)
+			time.Sleep(10 * time.Millisecond)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		time.Sleep(10 * time.Millisecond)
+		w.Write([]byte("body"))
+		time.Sleep(10 * time.Millisecond)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/body", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/redirect", nil))
+
+	_, g := s.Registry.Snapshot()
+	ms := func(series string) int64 { return g[series] / 1000 }
+
+	// the handler sleeps 10ms before each step, so each timing is at least 10ms after the one before.
+	tth, ttfb := ms("http_response,route=/body time_to_headers.max"), ms("http_response,route=/body time_to_first_byte.max")
+	lat, d := ms("http_response,route=/body latency.max"), ms("http_response,route=/body duration.max")
+	assert.True(t, tth >= 10, "time to headers was %dms", tth)
+	assert.True(t, ttfb >= tth+10, "time to first byte was %dms after headers at %dms", ttfb, tth)
+	assert.True(t, lat >= ttfb, "latency was %dms before first byte at %dms", lat, ttfb)
+	assert.True(t, d >= lat+10, "duration was %dms after latency of %dms", d, lat)
+
+	// a response which only writes its headers reports the time it wrote them as latency.
+	tth, lat = ms("http_response,route=/redirect time_to_headers.max"), ms("http_response,route=/redirect latency.max")
+	d = ms("http_response,route=/redirect duration.max")
+	assert.True(t, tth >= 10, "time to headers was %dms", tth)
+	assert.Equal(t, tth, lat)
+	assert.True(t, d >= lat+10, "duration was %dms after latency of %dms", d, lat)
+	assert.NotContains(t, g, "http_response,route=/redirect time_to_first_byte.max")
+}
+
+func TestHTTPStatsRequestBody(t *testing.T) {
+	t.Parallel()
+	s := NewHTTPStats(map[string]string{"foo": "bar"}, WithRegistry(metrics.NewRegistry()))
+	h := s.HTTPStatsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, 1<<20)
+		ioutil.ReadAll(r.Body)
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/upload", strings.NewReader(strings.Repeat("a", 1000))))
+
+	// without Content-Length
+	r := httptest.NewRequest("POST", "/upload", struct{ io.Reader }{strings.NewReader(strings.Repeat("b", 500))})
+	r.TransferEncoding = []string{"chunked"}
+	assert.Equal(t, int64(-1), r.ContentLength)
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	// no body
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+
+	c, g := s.Registry.Snapshot()
+	assert.Equal(t, uint64(1500), c["http_request,foo=bar body_bytes"])
+	assert.Equal(t, uint64(2), c["http_request,foo=bar body_size.count"])
+	// body_size has 2 significant figures, so sizes are reported within 1%.
+	assert.InDelta(t, 1000, g["http_request,foo=bar body_size.max"], 10)
+	assert.InDelta(t, 500, g["http_request,foo=bar body_size.min"], 5)
+	assert.Equal(t, uint64(1500), c["http_request,foo=bar upload.count"])
+	assert.Contains(t, g, "http_request,foo=bar upload.m1_rate")
+	assert.Equal(t, uint64(3), c["http_request,foo=bar throughput.count"])
+}
+
+// TestHTTPStatsRequestBodyLateReader reads the request body from a goroutine the handler left behind. Run with -race.
+func TestHTTPStatsRequestBodyLateReader(t *testing.T) {
+	t.Parallel()
+	s := NewHTTPStats(map[string]string{"foo": "bar"}, WithRegistry(metrics.NewRegistry()))
+	done := make(chan struct{})
+	h := s.HTTPStatsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		go func() {
+			defer close(done)
+			ioutil.ReadAll(r.Body)
+		}()
+	}))
+
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/upload", strings.NewReader(strings.Repeat("a", 1000))))
+	<-done
 
+	c, _ := s.Registry.Snapshot()
+	assert.Equal(t, uint64(1), c["http_request,foo=bar POST"])
 }
@@ -0,0 +1,71 @@
+package stats
+
+import (
+	"net/http"
+	"time"
+
+	gmux "github.com/gorilla/mux"
+	"github.com/supershal/stats/metrics"
+)
+
+// Option configures HTTPStats created by NewHTTPStats.
+type Option func(*HTTPStats)
+
+// WithRegistry collects stats into registry r instead of metrics.DefaultRegistry.
+// Use a separate registry for each HTTPStats that must not share counters, e.g. a public and an admin API.
+func WithRegistry(r *metrics.Registry) Option {
+	return func(s *HTTPStats) {
+		s.Registry = r
+	}
+}
+
+// WithLatencyHistogram configures the range, significant figures, quantiles and window of the response latency histogram.
+// Min and Max are in units of the latency unit, see WithLatencyUnit. Default is 0 to 10000 with 3 significant figures
+// and metrics.DefaultQuantiles over the last minute. A zero Max or Window keeps its default, so opts may set only
+// the Quantiles, for example.
+func WithLatencyHistogram(opts metrics.HistogramOptions) Option {
+	return func(s *HTTPStats) {
+		s.Latency = opts
+	}
+}
+
+// WithLatencyUnit records response latency in multiples of unit, e.g. time.Microsecond. Default is time.Millisecond.
+func WithLatencyUnit(unit time.Duration) Option {
+	return func(s *HTTPStats) {
+		s.LatencyUnit = unit
+	}
+}
+
+// WithConcurrencyWindow starts the max_concurrency gauge over from the number of requests in flight every window.
+// Default is one minute. Choose the interval of the reporter to get the peak of each report.
+func WithConcurrencyWindow(window time.Duration) Option {
+	return func(s *HTTPStats) {
+		s.ConcurrencyWindow = window
+	}
+}
+
+// RouteFunc returns the route template matched by request r, e.g. /users/{id}, or an empty string if none matched.
+type RouteFunc func(r *http.Request) string
+
+// WithRouteTag tags request and response stats with the route template returned by f under the "route" tag.
+// Unlike the raw request URI, a route template keeps the number of series bounded.
+func WithRouteTag(f RouteFunc) Option {
+	return func(s *HTTPStats) {
+		s.Route = f
+	}
+}
+
+// MuxRoute is a RouteFunc which returns the path template of the gorilla/mux route matched by r.
+// The stats middleware must run after routing to see the route, for example registered with Router.Use
+// or wrapped around each route handler.
+func MuxRoute(r *http.Request) string {
+	route := gmux.CurrentRoute(r)
+	if route == nil {
+		return ""
+	}
+	tpl, err := route.GetPathTemplate()
+	if err != nil {
+		return ""
+	}
+	return tpl
+}
@@ -0,0 +1,35 @@
+package stats
+
+import (
+	"io"
+	"net/http"
+	"sync/atomic"
+)
+
+// countingBody counts the bytes read from a request body. It counts whatever is read, so it works for
+// requests without Content-Length and chunked requests alike.
+type countingBody struct {
+	io.ReadCloser
+	n int64 // accessed atomically.
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	atomic.AddInt64(&b.n, int64(n))
+	return n, err
+}
+
+// BytesRead returns the number of bytes read from the body so far.
+func (b *countingBody) BytesRead() int64 {
+	return atomic.LoadInt64(&b.n)
+}
+
+// countBody replaces the body of request r by a countingBody and returns it, or nil if r has no body.
+func countBody(r *http.Request) *countingBody {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	b := &countingBody{ReadCloser: r.Body}
+	r.Body = b
+	return b
+}
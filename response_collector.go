@@ -1,7 +1,11 @@
 package stats
 
 import (
+	"bufio"
+	"io"
+	"net"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -13,13 +17,31 @@ type HTTPResponseStatCollector interface {
 	Latency() time.Duration
 }
 
-// statsWriter implements HTTPResponseStatCollector and collects response code, size and latency.
+// HTTPResponseTimingCollector is implemented by an HTTPResponseStatCollector which also times the phases of a response.
+type HTTPResponseTimingCollector interface {
+	// TimeToHeaders is the time until the response headers were written, explicitly or by the first write.
+	// If the handler wrote neither, the headers are sent when it returns and TimeToHeaders equals Duration.
+	TimeToHeaders() time.Duration
+	// TimeToFirstByte is the time until the first byte of the body was written, zero if the body is empty.
+	TimeToFirstByte() time.Duration
+	// Duration is the time the handler took to return.
+	Duration() time.Duration
+}
+
+// statsWriter implements HTTPResponseStatCollector and HTTPResponseTimingCollector and collects response code, size and latency.
+// Latency is the time until the response was last written to, including a response which only wrote its headers.
+// It is safe to read the stats while a hijacked connection or a handler goroutine which outlived the handler still writes.
 type StatsWriter struct {
 	Writer    http.ResponseWriter
 	StartTime time.Time
-	endTime   time.Time
+	mu        sync.Mutex // guards all fields below.
+	endTime   time.Time  // last write.
+	headers   time.Time  // headers written.
+	firstByte time.Time  // first body byte written.
+	finished  time.Time  // handler returned.
 	status    int
 	size      int
+	hijacked  bool
 }
 
 // Header returns the header map that will be sent by
@@ -38,17 +60,84 @@ func (l *StatsWriter) Header() http.Header {
 // Content-Type line, Write adds a Content-Type set to the result of passing
 // the initial 512 bytes of written data to DetectContentType.
 func (l *StatsWriter) Write(b []byte) (int, error) {
-	if l.status == 0 {
-		l.status = http.StatusOK
-	}
+	l.wroteHeader()
 	size, err := l.Writer.Write(b)
-	l.size += size
+	l.wrote(int64(size))
+	return size, err
+}
+
+// wroteHeader sets the status to http.StatusOK unless WriteHeader was called.
+func (l *StatsWriter) wroteHeader() {
+	l.writeHeader(http.StatusOK, false)
+}
+
+// writeHeader records the status and the time the headers were written, unless they were written before.
+// A status passed to WriteHeader explicitly replaces the status recorded so far.
+func (l *StatsWriter) writeHeader(status int, explicit bool) {
+	now := time.Now()
+	l.mu.Lock()
+	if l.status == 0 || explicit {
+		l.status = status
+	}
+	if l.headers.IsZero() {
+		l.headers = now
+		l.endTime = now
+	}
+	l.mu.Unlock()
+}
+
+// wrote adds size bytes to the response size and sets the end time.
+func (l *StatsWriter) wrote(size int64) {
+	now := time.Now()
+	l.mu.Lock()
+	l.size += int(size)
+	if size > 0 && l.firstByte.IsZero() {
+		l.firstByte = now
+	}
 	// add end time for each chunk of Write operation
-	if l.endTime.IsZero() {
-		l.endTime = l.StartTime
+	l.endTime = now
+	l.mu.Unlock()
+}
+
+// finish records that the handler returned.
+func (l *StatsWriter) finish() {
+	now := time.Now()
+	l.mu.Lock()
+	l.finished = now
+	l.mu.Unlock()
+}
+
+// flush sends buffered data of the response to the client through f, the Flusher of Writer.
+func (l *StatsWriter) flush(f http.Flusher) {
+	l.wroteHeader()
+	f.Flush()
+}
+
+// readFrom copies src to the response through r, the ReaderFrom of Writer, which may use sendfile.
+func (l *StatsWriter) readFrom(r io.ReaderFrom, src io.Reader) (int64, error) {
+	l.wroteHeader()
+	n, err := r.ReadFrom(src)
+	l.wrote(n)
+	return n, err
+}
+
+// hijack takes over the connection through h, the Hijacker of Writer. Once hijacked, the response is no longer
+// written through l, so its status, size and latency do not describe it.
+func (l *StatsWriter) hijack(h http.Hijacker) (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		l.mu.Lock()
+		l.hijacked = true
+		l.mu.Unlock()
 	}
-	l.endTime = l.endTime.Add(time.Now().Sub(l.endTime))
-	return size, err
+	return conn, rw, err
+}
+
+// Hijacked reports whether the connection was taken over by the handler through http.Hijacker.
+func (l *StatsWriter) Hijacked() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.hijacked
 }
 
 // WriteHeader sends an HTTP response header with status code.
@@ -58,16 +147,25 @@ func (l *StatsWriter) Write(b []byte) (int, error) {
 // send error codes.
 func (l *StatsWriter) WriteHeader(s int) {
 	l.Writer.WriteHeader(s)
-	l.status = s
+	l.writeHeader(s, true)
 }
 
 // Size function return current response size in bytes
 func (l *StatsWriter) Size() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
 	return l.size
 }
 
 // Status function returns current http status code
 func (l *StatsWriter) Status() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.statusCode()
+}
+
+// statusCode returns the status code, http.StatusOK if none was written. l.mu must be held.
+func (l *StatsWriter) statusCode() int {
 	if l.status == 0 {
 		return http.StatusOK
 	}
@@ -76,5 +174,98 @@ func (l *StatsWriter) Status() int {
 
 // Latency provides response time.
 func (l *StatsWriter) Latency() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.latency()
+}
+
+// latency returns the time until the last write, or the handler duration if nothing was written. l.mu must be held.
+func (l *StatsWriter) latency() time.Duration {
+	if l.endTime.IsZero() {
+		return l.duration()
+	}
 	return l.endTime.Sub(l.StartTime)
 }
+
+// TimeToHeaders provides the time until the response headers were written.
+func (l *StatsWriter) TimeToHeaders() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.timeToHeaders()
+}
+
+// timeToHeaders returns the time until the headers were written, or the handler duration. l.mu must be held.
+func (l *StatsWriter) timeToHeaders() time.Duration {
+	if l.headers.IsZero() {
+		return l.duration()
+	}
+	return l.headers.Sub(l.StartTime)
+}
+
+// TimeToFirstByte provides the time until the first byte of the body was written.
+func (l *StatsWriter) TimeToFirstByte() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.timeToFirstByte()
+}
+
+// timeToFirstByte returns the time until the first body byte was written, zero if none was. l.mu must be held.
+func (l *StatsWriter) timeToFirstByte() time.Duration {
+	if l.firstByte.IsZero() {
+		return 0
+	}
+	return l.firstByte.Sub(l.StartTime)
+}
+
+// Duration provides the time the handler took to return, or the time since StartTime while it did not return yet.
+func (l *StatsWriter) Duration() time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.duration()
+}
+
+// duration returns the handler duration so far. l.mu must be held.
+func (l *StatsWriter) duration() time.Duration {
+	if l.finished.IsZero() {
+		return time.Since(l.StartTime)
+	}
+	return l.finished.Sub(l.StartTime)
+}
+
+// Record returns the status code, size and latency of the response so far as an HTTPResponseStatCollector
+// which keeps these values when the StatsWriter is written to later. Its Header, Write and WriteHeader
+// methods still go to the StatsWriter.
+func (l *StatsWriter) Record() HTTPResponseStatCollector {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return &responseRecord{
+		ResponseWriter:  l,
+		status:          l.statusCode(),
+		size:            l.size,
+		latency:         l.latency(),
+		timeToHeaders:   l.timeToHeaders(),
+		timeToFirstByte: l.timeToFirstByte(),
+		duration:        l.duration(),
+		hijacked:        l.hijacked,
+	}
+}
+
+// responseRecord is an immutable copy of the stats of a StatsWriter.
+type responseRecord struct {
+	http.ResponseWriter
+	status          int
+	size            int
+	latency         time.Duration
+	timeToHeaders   time.Duration
+	timeToFirstByte time.Duration
+	duration        time.Duration
+	hijacked        bool
+}
+
+func (r *responseRecord) Status() int                    { return r.status }
+func (r *responseRecord) Size() int                      { return r.size }
+func (r *responseRecord) Latency() time.Duration         { return r.latency }
+func (r *responseRecord) TimeToHeaders() time.Duration   { return r.timeToHeaders }
+func (r *responseRecord) TimeToFirstByte() time.Duration { return r.timeToFirstByte }
+func (r *responseRecord) Duration() time.Duration        { return r.duration }
+func (r *responseRecord) Hijacked() bool                 { return r.hijacked }
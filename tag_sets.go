@@ -0,0 +1,74 @@
+package stats
+
+import (
+	"sync"
+)
+
+// tagSets holds a value for each tag set, such as the metric handles of the tags of one route. Once a tag set is
+// known, looking up its value takes a read lock and does not allocate, so series keys are built once per tag set
+// instead of on every request.
+type tagSets struct {
+	create func(tags map[string]string) interface{}
+	mu     sync.RWMutex
+	sets   map[string]interface{}
+}
+
+func newTagSets(create func(tags map[string]string) interface{}) *tagSets {
+	return &tagSets{
+		create: create,
+		sets:   make(map[string]interface{}),
+	}
+}
+
+// get returns the value of tags and creates it when it does not exist.
+func (t *tagSets) get(tags map[string]string) interface{} {
+	buf := keyBuffers.Get().(*[]byte)
+	key := appendTagsKey((*buf)[:0], tags)
+
+	t.mu.RLock()
+	v, ok := t.sets[string(key)]
+	t.mu.RUnlock()
+	if !ok {
+		t.mu.Lock()
+		if v, ok = t.sets[string(key)]; !ok {
+			v = t.create(tags)
+			t.sets[string(key)] = v
+		}
+		t.mu.Unlock()
+	}
+
+	*buf = key
+	keyBuffers.Put(buf)
+	return v
+}
+
+// keyBuffers reuses the buffers tag set keys are built in.
+var keyBuffers = sync.Pool{
+	New: func() interface{} {
+		b := make([]byte, 0, 128)
+		return &b
+	},
+}
+
+// appendTagsKey appends the tags sorted by key to buf as <tag1>\x00<value1>\x00<tagN>\x00<valueN>\x00
+func appendTagsKey(buf []byte, tags map[string]string) []byte {
+	// sort the keys in place without allocating, tag sets are small.
+	var a [16]string
+	keys := a[:0]
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	for i := 1; i < len(keys); i++ {
+		for j := i; j > 0 && keys[j] < keys[j-1]; j-- {
+			keys[j], keys[j-1] = keys[j-1], keys[j]
+		}
+	}
+
+	for _, k := range keys {
+		buf = append(buf, k...)
+		buf = append(buf, 0)
+		buf = append(buf, tags[k]...)
+		buf = append(buf, 0)
+	}
+	return buf
+}
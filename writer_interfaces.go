@@ -0,0 +1,294 @@
+package stats
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+)
+
+// Bits of the optional interfaces an http.ResponseWriter implements.
+const (
+	flusherBit = 1 << iota
+	hijackerBit
+	closeNotifierBit
+	pusherBit
+	readerFromBit
+)
+
+// unwrapper is an http.ResponseWriter which returns the writer it wraps. The wrappers returned by
+// StatsWriter.ResponseWriter embed it so http.ResponseController reaches the underlying writer.
+type unwrapper interface {
+	http.ResponseWriter
+	Unwrap() http.ResponseWriter
+}
+
+type flusherFunc func()
+
+func (f flusherFunc) Flush() { f() }
+
+type hijackerFunc func() (net.Conn, *bufio.ReadWriter, error)
+
+func (f hijackerFunc) Hijack() (net.Conn, *bufio.ReadWriter, error) { return f() }
+
+type closeNotifierFunc func() <-chan bool
+
+func (f closeNotifierFunc) CloseNotify() <-chan bool { return f() }
+
+type pusherFunc func(target string, opts *http.PushOptions) error
+
+func (f pusherFunc) Push(target string, opts *http.PushOptions) error { return f(target, opts) }
+
+type readerFromFunc func(src io.Reader) (int64, error)
+
+func (f readerFromFunc) ReadFrom(src io.Reader) (int64, error) { return f(src) }
+
+// Unwrap returns the wrapped Writer. It lets http.ResponseController control the underlying writer,
+// e.g. to set read and write deadlines.
+func (l *StatsWriter) Unwrap() http.ResponseWriter {
+	return l.Writer
+}
+
+// ResponseWriter returns the writer to pass to the next handler. It records the response like l and implements
+// exactly those of http.Flusher, http.Hijacker, http.CloseNotifier, http.Pusher and io.ReaderFrom which
+// Writer implements, so handlers can rely on type assertions to detect streaming, WebSocket upgrades,
+// HTTP/2 push and the sendfile fast path.
+func (l *StatsWriter) ResponseWriter() http.ResponseWriter {
+	var bits int
+	var (
+		flusher       http.Flusher
+		hijacker      http.Hijacker
+		closeNotifier http.CloseNotifier
+		pusher        http.Pusher
+		readerFrom    io.ReaderFrom
+	)
+	if f, ok := l.Writer.(http.Flusher); ok {
+		bits |= flusherBit
+		flusher = flusherFunc(func() { l.flush(f) })
+	}
+	if h, ok := l.Writer.(http.Hijacker); ok {
+		bits |= hijackerBit
+		hijacker = hijackerFunc(func() (net.Conn, *bufio.ReadWriter, error) { return l.hijack(h) })
+	}
+	if c, ok := l.Writer.(http.CloseNotifier); ok {
+		bits |= closeNotifierBit
+		closeNotifier = c
+	}
+	if p, ok := l.Writer.(http.Pusher); ok {
+		bits |= pusherBit
+		pusher = p
+	}
+	if r, ok := l.Writer.(io.ReaderFrom); ok {
+		bits |= readerFromBit
+		readerFrom = readerFromFunc(func(src io.Reader) (int64, error) { return l.readFrom(r, src) })
+	}
+
+	switch bits {
+	case 0:
+		return l
+	case 1:
+		return struct {
+			unwrapper
+			http.Flusher
+		}{l, flusher}
+	case 2:
+		return struct {
+			unwrapper
+			http.Hijacker
+		}{l, hijacker}
+	case 3:
+		return struct {
+			unwrapper
+			http.Flusher
+			http.Hijacker
+		}{l, flusher, hijacker}
+	case 4:
+		return struct {
+			unwrapper
+			http.CloseNotifier
+		}{l, closeNotifier}
+	case 5:
+		return struct {
+			unwrapper
+			http.Flusher
+			http.CloseNotifier
+		}{l, flusher, closeNotifier}
+	case 6:
+		return struct {
+			unwrapper
+			http.Hijacker
+			http.CloseNotifier
+		}{l, hijacker, closeNotifier}
+	case 7:
+		return struct {
+			unwrapper
+			http.Flusher
+			http.Hijacker
+			http.CloseNotifier
+		}{l, flusher, hijacker, closeNotifier}
+	case 8:
+		return struct {
+			unwrapper
+			http.Pusher
+		}{l, pusher}
+	case 9:
+		return struct {
+			unwrapper
+			http.Flusher
+			http.Pusher
+		}{l, flusher, pusher}
+	case 10:
+		return struct {
+			unwrapper
+			http.Hijacker
+			http.Pusher
+		}{l, hijacker, pusher}
+	case 11:
+		return struct {
+			unwrapper
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{l, flusher, hijacker, pusher}
+	case 12:
+		return struct {
+			unwrapper
+			http.CloseNotifier
+			http.Pusher
+		}{l, closeNotifier, pusher}
+	case 13:
+		return struct {
+			unwrapper
+			http.Flusher
+			http.CloseNotifier
+			http.Pusher
+		}{l, flusher, closeNotifier, pusher}
+	case 14:
+		return struct {
+			unwrapper
+			http.Hijacker
+			http.CloseNotifier
+			http.Pusher
+		}{l, hijacker, closeNotifier, pusher}
+	case 15:
+		return struct {
+			unwrapper
+			http.Flusher
+			http.Hijacker
+			http.CloseNotifier
+			http.Pusher
+		}{l, flusher, hijacker, closeNotifier, pusher}
+	case 16:
+		return struct {
+			unwrapper
+			io.ReaderFrom
+		}{l, readerFrom}
+	case 17:
+		return struct {
+			unwrapper
+			http.Flusher
+			io.ReaderFrom
+		}{l, flusher, readerFrom}
+	case 18:
+		return struct {
+			unwrapper
+			http.Hijacker
+			io.ReaderFrom
+		}{l, hijacker, readerFrom}
+	case 19:
+		return struct {
+			unwrapper
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{l, flusher, hijacker, readerFrom}
+	case 20:
+		return struct {
+			unwrapper
+			http.CloseNotifier
+			io.ReaderFrom
+		}{l, closeNotifier, readerFrom}
+	case 21:
+		return struct {
+			unwrapper
+			http.Flusher
+			http.CloseNotifier
+			io.ReaderFrom
+		}{l, flusher, closeNotifier, readerFrom}
+	case 22:
+		return struct {
+			unwrapper
+			http.Hijacker
+			http.CloseNotifier
+			io.ReaderFrom
+		}{l, hijacker, closeNotifier, readerFrom}
+	case 23:
+		return struct {
+			unwrapper
+			http.Flusher
+			http.Hijacker
+			http.CloseNotifier
+			io.ReaderFrom
+		}{l, flusher, hijacker, closeNotifier, readerFrom}
+	case 24:
+		return struct {
+			unwrapper
+			http.Pusher
+			io.ReaderFrom
+		}{l, pusher, readerFrom}
+	case 25:
+		return struct {
+			unwrapper
+			http.Flusher
+			http.Pusher
+			io.ReaderFrom
+		}{l, flusher, pusher, readerFrom}
+	case 26:
+		return struct {
+			unwrapper
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{l, hijacker, pusher, readerFrom}
+	case 27:
+		return struct {
+			unwrapper
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{l, flusher, hijacker, pusher, readerFrom}
+	case 28:
+		return struct {
+			unwrapper
+			http.CloseNotifier
+			http.Pusher
+			io.ReaderFrom
+		}{l, closeNotifier, pusher, readerFrom}
+	case 29:
+		return struct {
+			unwrapper
+			http.Flusher
+			http.CloseNotifier
+			http.Pusher
+			io.ReaderFrom
+		}{l, flusher, closeNotifier, pusher, readerFrom}
+	case 30:
+		return struct {
+			unwrapper
+			http.Hijacker
+			http.CloseNotifier
+			http.Pusher
+			io.ReaderFrom
+		}{l, hijacker, closeNotifier, pusher, readerFrom}
+	default:
+		return struct {
+			unwrapper
+			http.Flusher
+			http.Hijacker
+			http.CloseNotifier
+			http.Pusher
+			io.ReaderFrom
+		}{l, flusher, hijacker, closeNotifier, pusher, readerFrom}
+	}
+}
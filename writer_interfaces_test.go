@@ -0,0 +1,362 @@
+package stats
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/supershal/stats/metrics"
+)
+
+// fakeWriter implements all optional interfaces of an http.ResponseWriter and records which were called.
+type fakeWriter struct {
+	*httptest.ResponseRecorder
+	calls  []string
+	closed chan bool
+}
+
+func (f *fakeWriter) Flush() { f.calls = append(f.calls, "Flush") }
+
+func (f *fakeWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	f.calls = append(f.calls, "Hijack")
+	return nil, nil, nil
+}
+
+func (f *fakeWriter) CloseNotify() <-chan bool { return f.closed }
+
+func (f *fakeWriter) Push(target string, opts *http.PushOptions) error {
+	f.calls = append(f.calls, "Push")
+	return nil
+}
+
+func (f *fakeWriter) ReadFrom(src io.Reader) (int64, error) {
+	f.calls = append(f.calls, "ReadFrom")
+	return io.Copy(f.ResponseRecorder, src)
+}
+
+// withInterfaces returns f implementing only the optional interfaces selected by bits.
+func (f *fakeWriter) withInterfaces(bits int) http.ResponseWriter {
+	switch bits {
+	case 0:
+		return struct {
+			http.ResponseWriter
+		}{f}
+	case 1:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+		}{f, f}
+	case 2:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+		}{f, f}
+	case 3:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+		}{f, f, f}
+	case 4:
+		return struct {
+			http.ResponseWriter
+			http.CloseNotifier
+		}{f, f}
+	case 5:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.CloseNotifier
+		}{f, f, f}
+	case 6:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			http.CloseNotifier
+		}{f, f, f}
+	case 7:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.CloseNotifier
+		}{f, f, f, f}
+	case 8:
+		return struct {
+			http.ResponseWriter
+			http.Pusher
+		}{f, f}
+	case 9:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Pusher
+		}{f, f, f}
+	case 10:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			http.Pusher
+		}{f, f, f}
+	case 11:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+		}{f, f, f, f}
+	case 12:
+		return struct {
+			http.ResponseWriter
+			http.CloseNotifier
+			http.Pusher
+		}{f, f, f}
+	case 13:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.CloseNotifier
+			http.Pusher
+		}{f, f, f, f}
+	case 14:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			http.CloseNotifier
+			http.Pusher
+		}{f, f, f, f}
+	case 15:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.CloseNotifier
+			http.Pusher
+		}{f, f, f, f, f}
+	case 16:
+		return struct {
+			http.ResponseWriter
+			io.ReaderFrom
+		}{f, f}
+	case 17:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			io.ReaderFrom
+		}{f, f, f}
+	case 18:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			io.ReaderFrom
+		}{f, f, f}
+	case 19:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			io.ReaderFrom
+		}{f, f, f, f}
+	case 20:
+		return struct {
+			http.ResponseWriter
+			http.CloseNotifier
+			io.ReaderFrom
+		}{f, f, f}
+	case 21:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.CloseNotifier
+			io.ReaderFrom
+		}{f, f, f, f}
+	case 22:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			http.CloseNotifier
+			io.ReaderFrom
+		}{f, f, f, f}
+	case 23:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.CloseNotifier
+			io.ReaderFrom
+		}{f, f, f, f, f}
+	case 24:
+		return struct {
+			http.ResponseWriter
+			http.Pusher
+			io.ReaderFrom
+		}{f, f, f}
+	case 25:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Pusher
+			io.ReaderFrom
+		}{f, f, f, f}
+	case 26:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{f, f, f, f}
+	case 27:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.Hijacker
+			http.Pusher
+			io.ReaderFrom
+		}{f, f, f, f, f}
+	case 28:
+		return struct {
+			http.ResponseWriter
+			http.CloseNotifier
+			http.Pusher
+			io.ReaderFrom
+		}{f, f, f, f}
+	case 29:
+		return struct {
+			http.ResponseWriter
+			http.Flusher
+			http.CloseNotifier
+			http.Pusher
+			io.ReaderFrom
+		}{f, f, f, f, f}
+	case 30:
+		return struct {
+			http.ResponseWriter
+			http.Hijacker
+			http.CloseNotifier
+			http.Pusher
+			io.ReaderFrom
+		}{f, f, f, f, f}
+	default:
+		return f
+	}
+}
+
+func TestStatsWriterInterfaces(t *testing.T) {
+	for bits := 0; bits < 32; bits++ {
+		f := &fakeWriter{ResponseRecorder: httptest.NewRecorder(), closed: make(chan bool)}
+		sw := &StatsWriter{Writer: f.withInterfaces(bits)}
+		w := sw.ResponseWriter()
+
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		assert.True(t, ok, "Unwrap of %05b", bits)
+		if ok {
+			assert.True(t, u.Unwrap() == sw.Writer)
+		}
+
+		flusher, ok := w.(http.Flusher)
+		assert.Equal(t, bits&flusherBit != 0, ok, "Flusher of %05b", bits)
+		if ok {
+			flusher.Flush()
+			assert.Contains(t, f.calls, "Flush")
+		}
+
+		hijacker, ok := w.(http.Hijacker)
+		assert.Equal(t, bits&hijackerBit != 0, ok, "Hijacker of %05b", bits)
+		if ok {
+			hijacker.Hijack()
+			assert.Contains(t, f.calls, "Hijack")
+		}
+		assert.Equal(t, ok, sw.Hijacked())
+
+		notifier, ok := w.(http.CloseNotifier)
+		assert.Equal(t, bits&closeNotifierBit != 0, ok, "CloseNotifier of %05b", bits)
+		if ok {
+			assert.True(t, notifier.CloseNotify() == f.closed)
+		}
+
+		pusher, ok := w.(http.Pusher)
+		assert.Equal(t, bits&pusherBit != 0, ok, "Pusher of %05b", bits)
+		if ok {
+			pusher.Push("/style.css", nil)
+			assert.Contains(t, f.calls, "Push")
+		}
+
+		readerFrom, ok := w.(io.ReaderFrom)
+		assert.Equal(t, bits&readerFromBit != 0, ok, "ReaderFrom of %05b", bits)
+		if ok {
+			n, err := readerFrom.ReadFrom(strings.NewReader("hello"))
+			assert.NoError(t, err)
+			assert.Equal(t, int64(5), n)
+			assert.Contains(t, f.calls, "ReadFrom")
+			assert.Equal(t, 5, sw.Size())
+			assert.Equal(t, "hello", f.Body.String())
+		}
+	}
+}
+
+func TestHTTPStatsResponseController(t *testing.T) {
+	s := NewHTTPStats(map[string]string{"foo": "bar"}, WithRegistry(metrics.NewRegistry()))
+	server := httptest.NewServer(s.HTTPStatsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Now().Add(time.Minute)); err != nil {
+			t.Error(err)
+		}
+		w.Write([]byte("hello"))
+		if err := rc.Flush(); err != nil {
+			t.Error(err)
+		}
+	})))
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+
+	waitFor(t, func() bool {
+		c, _ := s.Registry.Snapshot()
+		return c["http_response,foo=bar 200"] == 1
+	})
+}
+
+func TestHTTPStatsHijacked(t *testing.T) {
+	s := NewHTTPStats(map[string]string{"foo": "bar"}, WithRegistry(metrics.NewRegistry()))
+	server := httptest.NewServer(s.HTTPStatsHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		rw.Flush()
+	})))
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "test")
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	assert.Equal(t, http.StatusSwitchingProtocols, res.StatusCode)
+
+	waitFor(t, func() bool {
+		c, _ := s.Registry.Snapshot()
+		return c["http_response,foo=bar hijacked"] == 1
+	})
+	c, _ := s.Registry.Snapshot()
+	assert.Equal(t, uint64(1), c["http_request,foo=bar GET"])
+	assert.NotContains(t, c, "http_response,foo=bar 200")
+	assert.NotContains(t, c, "http_response,foo=bar total")
+}